@@ -2,10 +2,11 @@ package main
 
 import (
 	"flag"
-	"io/ioutil"
 	"os"
 	"os/user"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/madislohmus/gosh"
 	"github.com/nsf/termbox-go"
@@ -29,23 +30,70 @@ type (
 	}
 
 	machine struct {
-		Name          string `json:"name"`
-		User          string `json:"user"`
-		Host          string `json:"host"`
-		Port          string `json:"port"`
+		Name          string   `json:"name"`
+		User          string   `json:"user"`
+		Host          string   `json:"host"`
+		Port          string   `json:"port"`
+		Groups        []string `json:"groups"`
+		Jump          string   `json:"jump"`
+		Logs          []string `json:"logs"`
+		Maintenance   []string `json:"maintenance"`
 		config        *gosh.Config
 		client        *ssh.Client
-		Load1         measurement `json:"load1"`
-		Load5         measurement `json:"load5"`
-		Load15        measurement `json:"load15"`
-		CPU           measurement `json:"cpu"`
-		Free          measurement `json:"free"`
-		Storage       measurement `json:"storage"`
-		Inode         measurement `json:"inode"`
-		Connections   measurement `json:"conns"`
-		Uptime        measurement `json:"utime"`
-		Services      measurement `json:"services"`
-		Nproc         int32       `json:"nproc"`
+		clientMutex   sync.Mutex
+		kube          *kubeNode
+		cpuSample     []uint64
+		raised        map[string]*raisedLevel
+		maintenance   time.Time
+		windows       []maintenanceWindow
+		ack           *acknowledgement
+		disconnected  bool
+		historyLog    jsonLines
+		diskSample    *counterSample
+		netSample     *counterSample
+		Load1         measurement            `json:"load1"`
+		Load5         measurement            `json:"load5"`
+		Load15        measurement            `json:"load15"`
+		CPU           measurement            `json:"cpu"`
+		IOWait        measurement            `json:"iowait"`
+		Steal         measurement            `json:"steal"`
+		CPUTimes      cpuTimes               `json:"-"`
+		Free          measurement            `json:"free"`
+		Swap          measurement            `json:"swap"`
+		Memory        memInfo                `json:"-"`
+		PressureCPU   measurement            `json:"psi_cpu"`
+		PressureMem   measurement            `json:"psi_memory"`
+		PressureIO    measurement            `json:"psi_io"`
+		Pressure      map[string]pressure    `json:"-"`
+		Storage       measurement            `json:"storage"`
+		Inode         measurement            `json:"inode"`
+		DiskIOPS      measurement            `json:"disk_iops"`
+		DiskBytes     measurement            `json:"disk_bytes"`
+		DiskRates     []diskRate             `json:"-"`
+		NetBytes      measurement            `json:"net_bytes"`
+		NetErrors     measurement            `json:"net_errors"`
+		NetRates      []netRate              `json:"-"`
+		Connections   measurement            `json:"conns"`
+		StateLimits   map[string]measurement `json:"conn_states"`
+		ConnStates    map[string]int32       `json:"-"`
+		ListenPorts   []int                  `json:"-"`
+		Peers         []peer                 `json:"-"`
+		Uptime        measurement            `json:"utime"`
+		Services      measurement            `json:"services"`
+		FailedUnits   measurement            `json:"units"`
+		Health        string                 `json:"health"`
+		HealthURL     string                 `json:"health_url"`
+		Collector     string                 `json:"collector"`
+		OS            string                 `json:"-"`
+		Kernel        string                 `json:"-"`
+		Checks        []healthCheck          `json:"-"`
+		Unhealthy     measurement            `json:"unhealthy"`
+		Restarting    measurement            `json:"restarting"`
+		ContainerList []container            `json:"-"`
+		Nproc         int32                  `json:"nproc"`
+		History       []sample               `json:"-"`
+		Trends        map[string]trend       `json:"-"`
+		InMaintenance bool                   `json:"-"`
 		Fetching      bool
 		GotResult     bool
 		Status        int
@@ -56,6 +104,10 @@ type (
 		Value   interface{}
 		Warning interface{} `json:"warning"`
 		Error   interface{} `json:"error"`
+		Ignore  []string    `json:"ignore"`
+		Rate    *limits     `json:"rate"`
+		FullIn  *limits     `json:"full_in"`
+		For     float64     `json:"for"`
 	}
 
 	machineSorter struct {
@@ -76,26 +128,32 @@ const (
 )
 
 var (
-	dataFile   = flag.String("data", "", "input file")
-	knownHosts = flag.String("h", "", "path to known hosts file (e.g. ~/.ssh/known_hosts)")
-	keyFile    = flag.String("key", "", "ssh key file")
-	passFile   = flag.String("pass", "", "key password file (optional)")
-	terminal   = flag.String("term", os.Getenv("TERM"), "terminal")
-	cmdFile    = flag.String("cmd", "", "command file")
-	sleepTime  = flag.Int("t", 300, "sleep time between refresh in seconds")
-
-	f1  string
-	f2  string
-	f3  string
-	f4  string
-	f5  string
-	f6  string
-	f7  string
-	f8  string
-	f9  string
-	f10 string
-	f11 string
-	f12 string
+	dataFile       = flag.String("data", "", "input file")
+	inventory      = flag.String("inventory", "", "ansible inventory file or dynamic inventory script")
+	consulCatalog  = flag.String("consul", "", "consul address to read the node catalog from (e.g. localhost:8500)")
+	kubeconfig     = flag.String("kubeconfig", "", "kubeconfig file, the nodes of its cluster are shown next to the other machines")
+	kubeContext    = flag.String("kube-context", "", "kubeconfig context to use instead of the current context")
+	defaultUser    = flag.String("user", currentUser(), "ssh user for inventory hosts without one")
+	defaultPort    = flag.String("port", "22", "ssh port for inventory hosts without one")
+	collectorName  = flag.String("collector", "shell", "default status collector (shell, proc)")
+	health         = flag.String("health", "consul", "default service health backend (consul, systemd, docker, supervisor, http)")
+	consulAgent    = flag.String("consul-agent", "localhost:8500", "consul agent address as seen from the machines, used for health checks")
+	consulToken    = flag.String("consul-token", "", "consul ACL token for health checks")
+	consulDC       = flag.String("consul-dc", "", "consul datacenter for health checks")
+	consulIgnore   = flag.String("consul-ignore", "", "comma separated consul check name patterns to ignore")
+	knownHosts     = flag.String("h", "", "path to known hosts file (e.g. ~/.ssh/known_hosts)")
+	keyFile        = flag.String("key", "", "ssh key file")
+	passFile       = flag.String("pass", "", "key password file (optional)")
+	terminal       = flag.String("term", os.Getenv("TERM"), "terminal")
+	consoleMode    = flag.String("console", "terminal", "where to open shells (terminal, embedded, tmux-window, tmux-pane)")
+	launchTemplate = flag.String("launch", "{term} -e {ssh}", "command template for opening a terminal")
+	sshTemplate    = flag.String("ssh", "ssh -t {user}@{host} -p {port} {cmd}", "ssh command template used in {ssh} of -launch")
+	historyDir     = flag.String("history", "", "directory to keep the history of the machines in (e.g. ~/.kone/history)")
+	retention      = flag.Duration("history-retention", 7*24*time.Hour, "how long samples are kept in the history")
+	eventFile      = flag.String("events", "", "file to append the event log to (e.g. ~/.kone/events.jsonl)")
+	cmdFile        = flag.String("cmd", "", "command file")
+	keysFile       = flag.String("keys", "", "key bindings file")
+	sleepTime      = flag.Int("t", 300, "sleep time between refresh in seconds")
 )
 
 func (s machineSorter) Len() int {
@@ -112,60 +170,16 @@ func (s machineSorter) Less(i, j int) bool {
 	m1 := machines[s.keys[i]]
 	m2 := machines[s.keys[j]]
 
-	if m1.Status == m2.Status {
+	if sortStatus(m1) == sortStatus(m2) {
 		return strings.ToLower(m1.Name) < strings.ToLower(m2.Name)
 	}
-	return m1.Status > m2.Status
+	return sortStatus(m1) > sortStatus(m2)
 }
 
-func getCommandsFromFile() error {
-	if strings.HasPrefix(*cmdFile, "~") {
-		u, err := user.Current()
-		if err != nil {
-			return err
-		}
-		*cmdFile = strings.Replace(*cmdFile, "~", u.HomeDir, 1)
-	}
-	data, err := ioutil.ReadFile(*cmdFile)
+func currentUser() string {
+	u, err := user.Current()
 	if err != nil {
-		return err
-	}
-	for _, line := range strings.Split(string(data), "\n") {
-		strs := strings.Split(line, "=")
-		if strs[0] == "F1" {
-			f1 = strs[1]
-		} else if strs[0] == "F2" {
-			f2 = strs[1]
-		} else if strs[0] == "F3" {
-			f3 = strs[1]
-		} else if strs[0] == "F4" {
-			f4 = strs[1]
-		} else if strs[0] == "F5" {
-			f5 = strs[1]
-		} else if strs[0] == "F6" {
-			f6 = strs[1]
-		} else if strs[0] == "F7" {
-			f7 = strs[1]
-		} else if strs[0] == "F8" {
-			f8 = strs[1]
-		} else if strs[0] == "F9" {
-			f9 = strs[1]
-		} else if strs[0] == "F10" {
-			f10 = strs[1]
-		} else if strs[0] == "F11" {
-			f11 = strs[1]
-		} else if strs[0] == "F12" {
-			f12 = strs[1]
-		}
-	}
-	return nil
-}
-
-func init() {
-	if !flag.Parsed() {
-		flag.Parse()
-	}
-	if *cmdFile != "" {
-		getCommandsFromFile()
+		return ""
 	}
+	return u.Username
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// acknowledgement marks the warnings / errors of a machine as being handled
+// by someone. It holds as long as the machine keeps the status it had when it
+// was acknowledged.
+type acknowledgement struct {
+	by      string
+	at      time.Time
+	comment string
+	status  int
+}
+
+func openAcknowledge(text string) {
+	openPrompt("acknowledge [@who] <comment> (- removes): ", text, acknowledge)
+}
+
+// acknowledge acknowledges the target machines that have warnings, errors or
+// can't be reached. The comment may start with @name to record someone else
+// than the local user, e.g. when several people watch the same screen.
+func acknowledge(targets []string, text string) {
+	by := currentUser()
+	if strings.HasPrefix(text, "@") {
+		fields := strings.SplitN(text, " ", 2)
+		by = strings.TrimPrefix(fields[0], "@")
+		text = ""
+		if len(fields) > 1 {
+			text = strings.TrimSpace(fields[1])
+		}
+	}
+	count := 0
+	for _, name := range targets {
+		m := machines[name]
+		if text == "-" {
+			if m.ack != nil {
+				m.ack = nil
+				count++
+				formatMachine(name)
+			}
+			continue
+		}
+		if worstStatus(m.Status) == statusOK && m.Status&statusUnknown == 0 {
+			continue
+		}
+		m.ack = &acknowledgement{by: by, at: time.Now(), comment: text, status: m.Status}
+		count++
+		formatMachine(name)
+	}
+	if text == "-" {
+		setStatusMessage("removed %d acknowledgements", count)
+	} else {
+		setStatusMessage("acknowledged %d machines", count)
+	}
+	sendRedrawRequest()
+}
+
+// updateAck drops the acknowledgement once the status of the machine has
+// changed.
+func updateAck(m *machine) {
+	if m.ack != nil && m.ack.status != m.Status {
+		m.ack = nil
+	}
+}
+
+// dimAcknowledged greys out the warning and error colours of the columns of
+// an acknowledged row like silent mode does for OK values, the name keeps its
+// colour.
+func dimAcknowledged(d *machine) {
+	if d.ack == nil {
+		return
+	}
+	for j := 1; j < len(tic.Header); j++ {
+		s := tic.Data[d.Name][j]
+		for i := range s.FG {
+			if fg := s.FG[i] &^ termbox.AttrBold; fg == 2 || fg == 4 {
+				s.FG[i] = 9
+			}
+		}
+	}
+}
+
+func ackLines(m *machine) []styledText {
+	var lines []styledText
+	if m.ack == nil {
+		return lines
+	}
+	lines = append(lines, sectionLine("Acknowledged"))
+	lines = append(lines, textLine("    by "+m.ack.by+" at "+m.ack.at.Format("2006-01-02 15:04"), termbox.ColorDefault))
+	if len(m.ack.comment) > 0 {
+		lines = append(lines, textLine("    "+m.ack.comment, termbox.ColorDefault))
+	}
+	return lines
+}
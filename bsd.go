@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type (
+	// bsdCollector reads the status values of FreeBSD and macOS machines
+	// from sysctl, netstat and df. Pressure, disk rates and failed units do
+	// not exist there.
+	bsdCollector struct {
+		darwin bool
+	}
+)
+
+var (
+	bsdCommands = [][2]string{
+		{"loadavg", "sysctl -n vm.loadavg"},
+		{"ncpu", "sysctl -n hw.ncpu"},
+		{"boottime", "sysctl -n kern.boottime"},
+		{"date", "date +%s"},
+		{"df", "df -P"},
+		{"df -i", "df -i"},
+		{"tcp", "netstat -an -p tcp"},
+		{"ifaces", "netstat -ibn"},
+	}
+
+	freebsdCommands = [][2]string{
+		{"cpu", "sysctl -n kern.cp_time"},
+		{"memory", "sysctl -n -i hw.pagesize vm.stats.vm.v_page_count vm.stats.vm.v_free_count vm.stats.vm.v_inactive_count vm.stats.vm.v_cache_count"},
+		{"swap", "swapinfo -k"},
+	}
+
+	darwinCommands = [][2]string{
+		{"cpu", "ps -A -o %cpu="},
+		{"memory", "sysctl -n hw.memsize; vm_stat"},
+		{"swap", "sysctl -n vm.swapusage"},
+	}
+
+	// bsdFileSystems are pseudo file systems left out of the storage
+	// column, by the device column of df.
+	bsdFileSystems = []string{"devfs", "fdescfs", "procfs", "linprocfs", "tmpfs", "map"}
+)
+
+// detectOS runs uname on the machine, the OS picks the default collector.
+func detectOS(m *machine) {
+	output, err := runOnMachine(m, "uname -sr")
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(output)
+	if len(fields) > 0 {
+		m.OS = fields[0]
+	}
+	if len(fields) > 1 {
+		m.Kernel = fields[1]
+	}
+}
+
+func (c bsdCollector) name() string {
+	if c.darwin {
+		return "darwin"
+	}
+	return "freebsd"
+}
+
+func (c bsdCollector) command(m *machine) string {
+	commands := append([][2]string{}, bsdCommands...)
+	if c.darwin {
+		commands = append(commands, darwinCommands...)
+	} else {
+		commands = append(commands, freebsdCommands...)
+	}
+	var b strings.Builder
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, `echo "%s%s"; (%s) 2>/dev/null; `, sectionMarker, cmd[0], cmd[1])
+	}
+	b.WriteString(`echo "` + sectionMarker + healthSection + `"`)
+	return b.String()
+}
+
+// populate turns the output into the lines of the shell collector, like the
+// proc collector does.
+func (c bsdCollector) populate(m *machine, output string) {
+	sections := splitSections(output)
+	ncpu := strings.TrimSpace(sections["ncpu"])
+	cpu := ""
+	if !c.darwin {
+		cpu = bsdCPUTimes(sections["cpu"])
+	}
+	lines := []string{
+		strings.Join(strings.Fields(strings.Trim(strings.TrimSpace(sections["loadavg"]), "{}")), " "),
+		bsdMemInfo(sections["memory"], sections["swap"], c.darwin),
+		bsdConnections(sections["tcp"]),
+		ncpu,
+		bsdUsages(sections["df"], "capacity"),
+		bsdUsages(sections["df -i"], "%iused"),
+		bsdUptime(sections["boottime"], sections["date"]),
+		cpu,
+		"-",
+		"",
+		"",
+		bsdNetDev(sections["ifaces"]),
+		sections[healthSection],
+	}
+	populate(m, strings.Join(lines, "\n"))
+	if c.darwin {
+		// ps gives a decaying average of the CPU usage per process, there
+		// is no breakdown.
+		total := float32(0)
+		for _, f := range strings.Fields(sections["cpu"]) {
+			v, err := strconv.ParseFloat(strings.Replace(f, ",", ".", 1), 32)
+			if err == nil {
+				total += float32(v)
+			}
+		}
+		if n, err := strconv.Atoi(ncpu); err == nil && n > 0 {
+			m.CPU.Value = total / float32(n)
+		}
+	}
+}
+
+// bsdCPUTimes maps the user, nice, sys, intr and idle ticks of kern.cp_time
+// to a /proc/stat cpu line.
+func bsdCPUTimes(text string) string {
+	f := strings.Fields(text)
+	if len(f) < 5 {
+		return ""
+	}
+	return strings.Join([]string{"cpu", f[0], f[1], f[2], f[4], "0", f[3], "0", "0"}, " ")
+}
+
+// bsdMemInfo builds the /proc/meminfo fields used by populateMemory, in kB.
+func bsdMemInfo(memory, swap string, darwin bool) string {
+	var total, available, swapTotal, swapUsed float64
+	if darwin {
+		lines := strings.Split(memory, "\n")
+		total, _ = strconv.ParseFloat(strings.TrimSpace(lines[0]), 64)
+		pageSize := 4096.0
+		pages := 0.0
+		for _, line := range lines[1:] {
+			if i := strings.Index(line, "page size of "); i >= 0 {
+				if f := strings.Fields(line[i+len("page size of "):]); len(f) > 0 {
+					pageSize, _ = strconv.ParseFloat(f[0], 64)
+				}
+				continue
+			}
+			kv := strings.SplitN(line, ":", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "Pages free", "Pages inactive", "Pages speculative":
+				v, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(kv[1]), "."), 64)
+				pages += v
+			}
+		}
+		available = pages * pageSize
+		// total = 2048.00M  used = 1024.50M  free = 1023.50M  (encrypted)
+		f := strings.Fields(swap)
+		for i := 0; i+2 < len(f); i++ {
+			if f[i+1] != "=" {
+				continue
+			}
+			switch f[i] {
+			case "total":
+				swapTotal = parseSize(f[i+2])
+			case "used":
+				swapUsed = parseSize(f[i+2])
+			}
+		}
+	} else {
+		var v []float64
+		for _, s := range strings.Fields(memory) {
+			n, _ := strconv.ParseFloat(s, 64)
+			v = append(v, n)
+		}
+		for len(v) < 5 {
+			v = append(v, 0)
+		}
+		total = v[0] * v[1]
+		available = v[0] * (v[2] + v[3] + v[4])
+		for _, line := range strings.Split(swap, "\n") {
+			f := strings.Fields(line)
+			if len(f) < 3 || !strings.HasPrefix(f[0], "/dev/") {
+				continue
+			}
+			t, _ := strconv.ParseFloat(f[1], 64)
+			u, _ := strconv.ParseFloat(f[2], 64)
+			swapTotal += t * 1024
+			swapUsed += u * 1024
+		}
+	}
+	if total == 0 {
+		return ""
+	}
+	kb := func(b float64) int64 { return int64(b / 1024) }
+	return fmt.Sprintf("MemTotal:%d MemAvailable:%d SwapTotal:%d SwapFree:%d", kb(total), kb(available), kb(swapTotal), kb(swapTotal-swapUsed))
+}
+
+// parseSize parses sizes like 1024.50M to bytes.
+func parseSize(s string) float64 {
+	units := "KMGT"
+	mult := 1.0
+	if len(s) > 0 {
+		if i := strings.IndexByte(units, s[len(s)-1]); i >= 0 {
+			for j := 0; j <= i; j++ {
+				mult *= 1024
+			}
+			s = s[:len(s)-1]
+		}
+	}
+	v, _ := strconv.ParseFloat(s, 64)
+	return v * mult
+}
+
+// bsdConnections parses netstat -an, addresses end with .port.
+func bsdConnections(text string) string {
+	states := make(map[string]int)
+	ports := make(map[int64]bool)
+	peers := make(map[string]int)
+	for _, line := range strings.Split(text, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 6 || !strings.HasPrefix(f[0], "tcp") {
+			continue
+		}
+		state := normalizeState(f[5])
+		states[state]++
+		if state == listenState {
+			i := strings.LastIndex(f[3], ".")
+			if port, err := strconv.ParseInt(f[3][i+1:], 10, 64); err == nil {
+				ports[port] = true
+			}
+		} else if i := strings.LastIndex(f[4], "."); i > 0 {
+			peers[f[4][:i]]++
+		}
+	}
+	return connectionsLine(states, ports, peers)
+}
+
+// bsdUsages reads the usage column with the given header from df output as
+// "mount=use%" fields. The mount point is the last column.
+func bsdUsages(text, column string) string {
+	lines := strings.Split(text, "\n")
+	index := -1
+	for i, h := range strings.Fields(lines[0]) {
+		if strings.ToLower(h) == column {
+			index = i
+		}
+	}
+	if index < 0 {
+		return ""
+	}
+	var usages []string
+	for _, line := range lines[1:] {
+		f := strings.Fields(line)
+		if len(f) <= index || !strings.HasSuffix(f[index], "%") || !strings.HasPrefix(f[len(f)-1], "/") {
+			continue
+		}
+		if contains(bsdFileSystems, f[0]) {
+			continue
+		}
+		usages = append(usages, f[len(f)-1]+"="+f[index])
+	}
+	return strings.Join(usages, " ")
+}
+
+// bsdUptime computes the uptime from kern.boottime, e.g.
+// "{ sec = 1697040000, usec = 0 } Wed Oct 11 ...", and the remote time.
+func bsdUptime(boottime, date string) string {
+	i := strings.Index(boottime, "sec = ")
+	if i < 0 {
+		return ""
+	}
+	f := strings.FieldsFunc(boottime[i+len("sec = "):], func(r rune) bool { return r == ',' || r == ' ' })
+	if len(f) == 0 {
+		return ""
+	}
+	boot, err := strconv.ParseInt(f[0], 10, 64)
+	if err != nil {
+		return ""
+	}
+	now, err := strconv.ParseInt(strings.TrimSpace(date), 10, 64)
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatInt(now-boot, 10)
+}
+
+// bsdNetDev reads the byte and error counters of the link level lines of
+// netstat -ibn. Interfaces without an address have one column less, so the
+// counters are located from the end of the line.
+func bsdNetDev(text string) string {
+	lines := strings.Split(text, "\n")
+	header := strings.Fields(lines[0])
+	fromEnd := make(map[string]int)
+	for i, h := range header {
+		fromEnd[h] = len(header) - i
+	}
+	for _, h := range []string{"Ibytes", "Ierrs", "Obytes", "Oerrs"} {
+		if _, ok := fromEnd[h]; !ok {
+			return ""
+		}
+	}
+	var parts []string
+	seen := make(map[string]bool)
+	for _, line := range lines[1:] {
+		f := strings.Fields(line)
+		if len(f) < len(header)-1 || len(f) < 3 || !strings.HasPrefix(f[2], "<Link") || seen[f[0]] {
+			continue
+		}
+		name := strings.TrimSuffix(f[0], "*")
+		if strings.HasPrefix(name, "lo") {
+			continue
+		}
+		seen[f[0]] = true
+		value := func(h string) string { return f[len(f)-fromEnd[h]] }
+		parts = append(parts, strings.Join([]string{name, value("Ibytes"), value("Ierrs"), value("Obytes"), value("Oerrs")}, ":"))
+	}
+	return strings.Join(parts, " ")
+}
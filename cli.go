@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"math"
-	"os/exec"
 	"strings"
 	"sync"
 	"time"
@@ -20,11 +19,17 @@ const (
 	hLoad     = "load"
 	hCPU      = "CPU"
 	hFree     = "free"
+	hPressure = "psi"
 	hStorage  = "storage"
 	hInode    = "inode"
+	hDisk     = "disk"
+	hNet      = "net"
 	hCons     = "conns"
 	hUptime   = "uptime"
 	hServices = "services"
+	hUnits    = "units"
+	hCtrs     = "containers"
+	hKube     = "k8s"
 )
 
 var (
@@ -36,6 +41,7 @@ var (
 	cursorPosition   = 0
 	matchingCount    = 0
 	matchingMachines = make(map[string]bool)
+	marked           = make(map[string]bool)
 
 	silent         bool
 	showIPs        bool
@@ -47,9 +53,12 @@ var (
 
 	errorLayerMutex  sync.Mutex
 	redrawMutex      sync.Mutex
+	suspended        bool
 	columnWidthMutex sync.Mutex
 	searchString     string
 	indexFormat      string
+	statusMessage    string
+	statusMutex      sync.Mutex
 
 	smallCircle = '\u00b7'
 )
@@ -61,7 +70,10 @@ func newStyledText() styledText {
 func initMachines(m map[string]*machine) {
 	tic = textInColumns{}
 	errorLayer = make(map[string]string)
-	tic.Header = []string{hMachine, hLoad, hCPU, hFree, hStorage, hInode, hCons, hUptime, hServices}
+	tic.Header = []string{hMachine, hLoad, hCPU, hFree, hPressure, hStorage, hInode, hDisk, hNet, hCons, hUptime, hServices, hUnits, hCtrs}
+	if len(*kubeconfig) > 0 {
+		tic.Header = append(tic.Header, hKube)
+	}
 	tic.Data = make(map[string][]styledText)
 	tic.ColumnWidth = make(map[string]int)
 	headerToIndex = make(map[string]int)
@@ -75,11 +87,17 @@ func initMachines(m map[string]*machine) {
 		hLoad:     alignCentre,
 		hCPU:      alignRight,
 		hFree:     alignRight,
+		hPressure: alignCentre,
 		hStorage:  alignRight,
 		hInode:    alignRight,
+		hDisk:     alignRight,
+		hNet:      alignRight,
 		hCons:     alignRight,
 		hUptime:   alignRight,
 		hServices: alignLeft,
+		hUnits:    alignRight,
+		hCtrs:     alignRight,
+		hKube:     alignLeft,
 	}
 	for k := range m {
 		tic.Data[k] = make([]styledText, len(tic.Header))
@@ -93,6 +111,11 @@ func initMachines(m map[string]*machine) {
 
 func redraw() {
 	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	if activePane != nil {
+		drawPane(activePane)
+		termbox.Flush()
+		return
+	}
 	adjustStartPosition()
 	drawDate()
 	drawHeader()
@@ -110,6 +133,12 @@ func redraw() {
 			drawAtIndex(i, k, false)
 		}
 	}
+	if palette {
+		drawPalette()
+	}
+	if showHelp {
+		drawHelp()
+	}
 	termbox.Flush()
 }
 
@@ -121,15 +150,26 @@ func formatAll() {
 
 func formatMachine(machine string) {
 	d := machines[machine]
-	if d.GotResult {
+	if d.GotResult && d.kube != nil {
+		clearInfo(machine)
+		formatKube(d)
+		errorLayerMutex.Lock()
+		delete(errorLayer, machine)
+		errorLayerMutex.Unlock()
+	} else if d.GotResult {
 		formatLoad(d)
 		formatCPU(d)
 		formatFree(d)
+		formatPressure(d)
 		formatStorage(d)
 		formatInode(d)
+		formatDisk(d)
+		formatNet(d)
 		formatCons(d)
 		formatUptime(d)
 		formatServices(d)
+		formatFailedUnits(d)
+		formatContainers(d)
 		errorLayerMutex.Lock()
 		delete(errorLayer, machine)
 		errorLayerMutex.Unlock()
@@ -141,6 +181,7 @@ func formatMachine(machine string) {
 			errorLayerMutex.Unlock()
 		}
 	}
+	dimAcknowledged(d)
 	formatName(d)
 }
 
@@ -210,6 +251,12 @@ func formatName(d *machine) {
 			s.BG = append(s.BG, termbox.ColorDefault)
 		}
 	}
+	if inMaintenance(d) {
+		appendStyled(&s, textLine(" [maint]", 9))
+	}
+	if d.ack != nil {
+		appendStyled(&s, textLine(" [ack "+d.ack.by+"]", 9))
+	}
 	rowToHeader(&s, d.Name, hMachine)
 }
 
@@ -231,6 +278,12 @@ func formatCPU(d *machine) {
 	s := newStyledText()
 	status := getCPUStatus(d)
 	formatText(fmt.Sprintf("%.1f", d.CPU.Value.(float32)), status, &s)
+	if st := getIOWaitStatus(d); st != statusOK {
+		formatText(fmt.Sprintf(" w%.0f", d.CPUTimes.IOWait), st, &s)
+	}
+	if st := getStealStatus(d); st != statusOK {
+		formatText(fmt.Sprintf(" s%.0f", d.CPUTimes.Steal), st, &s)
+	}
 	for _, r := range fmt.Sprintf(":%d", d.Nproc) {
 		if silent {
 			s.Runes = append(s.Runes, ' ')
@@ -247,9 +300,31 @@ func formatFree(d *machine) {
 	s := newStyledText()
 	status := getFreeStatus(d)
 	formatText(fmt.Sprintf("%.2f", d.Free.Value.(float32)), status, &s)
+	if st := getSwapStatus(d); st != statusOK {
+		formatText(fmt.Sprintf(" s%.2f", d.Swap.Value.(float32)), st, &s)
+	}
 	rowToHeader(&s, d.Name, hFree)
 }
 
+func formatPressure(d *machine) {
+	s := newStyledText()
+	if len(d.Pressure) == 0 {
+		appendNoData(&s)
+	}
+	for i, r := range pressureResources {
+		p, ok := d.Pressure[r]
+		if !ok {
+			continue
+		}
+		formatStr := "%.0f"
+		if i+1 < len(pressureResources) {
+			formatStr += " "
+		}
+		formatText(fmt.Sprintf(formatStr, p.Avg10), getPressureStatus(d, r), &s)
+	}
+	rowToHeader(&s, d.Name, hPressure)
+}
+
 func formatStorage(d *machine) {
 	s := newStyledText()
 	warn, ok := d.Storage.Warning.(float64)
@@ -265,9 +340,18 @@ func formatStorage(d *machine) {
 		formatText(fmt.Sprintf("%3d", datum), status, &s)
 	}
 
+	formatTrendSuffix(d, "storage", &s)
 	rowToHeader(&s, d.Name, hStorage)
 }
 
+// formatTrendSuffix adds the trend of the metric when it is above its
+// limits.
+func formatTrendSuffix(d *machine, metric string, s *styledText) {
+	if t, ok := getTrend(d, metric); ok && t.Status != statusOK {
+		formatText(" "+formatTrend(t, "%.1f"), t.Status, s)
+	}
+}
+
 func formatInode(d *machine) {
 	s := newStyledText()
 	warn, ok := d.Inode.Warning.(float64)
@@ -282,6 +366,7 @@ func formatInode(d *machine) {
 		status := getSingleStorageStatus(datum, warn, err)
 		formatText(fmt.Sprintf("%3d", datum), status, &s)
 	}
+	formatTrendSuffix(d, "inode", &s)
 	rowToHeader(&s, d.Name, hInode)
 }
 
@@ -353,6 +438,77 @@ func formatServices(d *machine) {
 	rowToHeader(&s, d.Name, hServices)
 }
 
+func formatFailedUnits(d *machine) {
+	s := newStyledText()
+	units := getFailedUnits(d)
+	if units == nil {
+		appendNoData(&s)
+	} else {
+		formatText(fmt.Sprintf("%d", len(units)), getFailedUnitsStatus(d), &s)
+	}
+	rowToHeader(&s, d.Name, hUnits)
+}
+
+// formatKube shows the worst node condition, the pod count and the CPU and
+// memory requests in percent of the allocatable resources.
+func formatKube(d *machine) {
+	s := newStyledText()
+	n := d.kube
+	condition, status := n.worstCondition()
+	formatText(condition+" ", status, &s)
+	formatText(fmt.Sprintf("%d/%.0f ", n.pods, n.podCapacity), getKubePodsStatus(n), &s)
+	formatText(fmt.Sprintf("cpu %.0f%% ", percentOf(n.cpuRequested, n.cpuAllocatable)), getKubeCPUStatus(n), &s)
+	formatText(fmt.Sprintf("mem %.0f%%", percentOf(n.memRequested, n.memAllocatable)), getKubeMemoryStatus(n), &s)
+	rowToHeader(&s, d.Name, hKube)
+}
+
+// formatContainers shows the running, exited and unhealthy container counts
+// and the restarting count when there are restarting containers.
+func formatContainers(d *machine) {
+	s := newStyledText()
+	if d.ContainerList == nil {
+		appendNoData(&s)
+	} else {
+		running, exited, unhealthy := countContainers(d)
+		formatText(fmt.Sprintf("%d ", running), statusOK, &s)
+		formatText(fmt.Sprintf("%d ", exited), statusOK, &s)
+		formatText(fmt.Sprintf("%d", unhealthy), getUnhealthyStatus(d), &s)
+		if restarting := d.Restarting.Value.(int32); restarting > 0 {
+			formatText(fmt.Sprintf(" r%d", restarting), getRestartingStatus(d), &s)
+		}
+	}
+	rowToHeader(&s, d.Name, hCtrs)
+}
+
+// formatDisk shows the IOPS and bytes per second of the busiest device.
+func formatDisk(d *machine) {
+	s := newStyledText()
+	iops, ok := d.DiskIOPS.Value.(float32)
+	if !ok {
+		appendNoData(&s)
+	} else {
+		formatText(fmt.Sprintf("%.0f ", iops), getValueStatus(d.DiskIOPS, math.Inf(1), math.Inf(1)), &s)
+		formatText(formatRate(float64(d.DiskBytes.Value.(float32))), getValueStatus(d.DiskBytes, math.Inf(1), math.Inf(1)), &s)
+	}
+	rowToHeader(&s, d.Name, hDisk)
+}
+
+// formatNet shows the bytes per second of the busiest interface and the
+// error rate when there are errors.
+func formatNet(d *machine) {
+	s := newStyledText()
+	bytes, ok := d.NetBytes.Value.(float32)
+	if !ok {
+		appendNoData(&s)
+	} else {
+		formatText(formatRate(float64(bytes)), getValueStatus(d.NetBytes, math.Inf(1), math.Inf(1)), &s)
+		if errors := d.NetErrors.Value.(float32); errors > 0 {
+			formatText(fmt.Sprintf(" e%.1f", errors), getValueStatus(d.NetErrors, 1, 10), &s)
+		}
+	}
+	rowToHeader(&s, d.Name, hNet)
+}
+
 func formatText(text string, status int, s *styledText) {
 	for i, r := range text {
 		if silent && status == statusOK {
@@ -413,6 +569,16 @@ func drawStatusBar() {
 		for i, r := range fmt.Sprintf("search: %s", searchString) {
 			termbox.SetCell(i+1, h-1, r, 2, termbox.ColorDefault)
 		}
+	} else if msg := getStatusMessage(); len(msg) > 0 {
+		for i, r := range msg {
+			termbox.SetCell(i+1, h-1, r, 9, termbox.ColorDefault)
+		}
+	}
+	if len(marked) > 0 {
+		label := fmt.Sprintf("[%d marked]", len(marked))
+		for i, r := range label {
+			termbox.SetCell(w-14-len(label)+i, h-1, r, 2, termbox.ColorDefault)
+		}
 	}
 	if showIPs {
 		for i, r := range "[IP]" {
@@ -464,6 +630,9 @@ func drawAtIndex(i int, name string, flush bool) {
 	for j := 0; j < w; j++ {
 		termbox.SetCell(j, row, ' ', termbox.ColorDefault, bg)
 	}
+	if marked[name] {
+		termbox.SetCell(0, row, '*', termbox.ColorYellow|termbox.AttrBold, bg)
+	}
 	currentTab := 1
 	index := fmt.Sprintf(indexFormat, i+1)
 	for j, r := range index {
@@ -544,26 +713,6 @@ func getSelectedMachine() *machine {
 	return machines[key]
 }
 
-func openConsole(command string) {
-	m := getSelectedMachine()
-	name := m.Name
-	user := m.config.User
-	if len(strings.TrimSpace(command)) > 0 {
-		command = fmt.Sprintf("%s; bash -l", command)
-	}
-	if len(*terminal) == 0 {
-		*terminal = "urxvt"
-	}
-	params := []string{"-e", "ssh", "-t", fmt.Sprintf("%s@%s", user, name), "-p", m.Port, command}
-	cmd := exec.Command(*terminal, params...)
-	go func() {
-		err := cmd.Run()
-		if err != nil {
-			fmt.Println(err.Error())
-		}
-	}()
-}
-
 func handleArrowUp() {
 	if cursorPosition > 0 {
 		if cursorPosition == startPosition {
@@ -671,7 +820,10 @@ func handleCtrlA() {
 }
 
 func handleCtrlR() {
-	m := getSelectedMachine()
+	refreshMachine(getSelectedMachine())
+}
+
+func refreshMachine(m *machine) {
 	if !m.Fetching {
 		go func(forceReConnect bool) {
 			fetchTime = time.Now()
@@ -681,6 +833,45 @@ func handleCtrlR() {
 	}
 }
 
+func toggleMark() {
+	name := getSelectedMachine().Name
+	if marked[name] {
+		delete(marked, name)
+	} else {
+		marked[name] = true
+	}
+	handleArrowDown()
+}
+
+// toggleMarkAll marks all machines of the search result, or unmarks them if
+// they are all marked already.
+func toggleMarkAll() {
+	visible := getVisibleMachines()
+	all := true
+	for _, k := range visible {
+		if !marked[k] {
+			all = false
+		}
+	}
+	for _, k := range visible {
+		if all {
+			delete(marked, k)
+		} else {
+			marked[k] = true
+		}
+	}
+}
+
+func getMarkedMachines() []string {
+	var names []string
+	for _, k := range sorter.keys {
+		if marked[k] {
+			names = append(names, k)
+		}
+	}
+	return names
+}
+
 func handleKeyPressInSearch(r rune) {
 	if r > 31 && r < 127 && len(searchString) < 50 {
 		searchString += string(r)
@@ -709,47 +900,27 @@ func putToColumnWidthMap(key string, value int) {
 
 func keyLoop() {
 loop:
-	for {
+	for !quit {
 		switch ev := termbox.PollEvent(); ev.Type {
 		case termbox.EventKey:
-			switch ev.Key {
-			case termbox.KeyF1:
-				openConsole(f1)
-			case termbox.KeyF2:
-				openConsole(f2)
-			case termbox.KeyF3:
-				openConsole(f3)
-			case termbox.KeyF4:
-				openConsole(f4)
-			case termbox.KeyF5:
-				openConsole(f5)
-			case termbox.KeyF6:
-				openConsole(f6)
-			case termbox.KeyF7:
-				openConsole(f7)
-			case termbox.KeyF8:
-				openConsole(f8)
-			case termbox.KeyF9:
-				openConsole(f9)
-			case termbox.KeyF10:
-				openConsole(f10)
-			case termbox.KeyF11:
-				openConsole(f11)
-			case termbox.KeyF12:
-				openConsole(f12)
-			case termbox.KeyCtrlA:
-				handleCtrlA()
-			case termbox.KeyCtrlR:
-				handleCtrlR()
-			case termbox.KeyCtrlF:
-				search = true
+			if activePane != nil {
+				handlePaneKey(activePane, ev)
+				continue
+			}
+			if palette {
+				handlePaletteKey(ev)
+				continue
+			}
+			if showHelp {
+				showHelp = false
 				sendRedrawRequest()
+				continue
+			}
+			switch ev.Key {
 			case termbox.KeyArrowUp:
 				handleArrowUp()
 			case termbox.KeyArrowDown:
 				handleArrowDown()
-			case termbox.KeyEnter:
-				openConsole("")
 			case termbox.KeyEnd:
 				handleKeyEnd()
 			case termbox.KeyHome:
@@ -773,27 +944,9 @@ loop:
 					break loop
 				}
 			}
-			if search {
+			if search && ev.Ch != 0 && ev.Mod&termbox.ModAlt == 0 {
 				handleKeyPressInSearch(ev.Ch)
-			} else {
-				switch ev.Ch {
-				case 102: // f
-					forceReConnect = !forceReConnect
-				case 115: // s - silent
-					silent = !silent
-					for _, h := range tic.Header {
-						l := len(h)
-						putToColumnWidthMap(h, l)
-					}
-					formatAll()
-				case 105: // i - show IP-s
-					showIPs = !showIPs
-					for _, h := range tic.Header {
-						l := len(h)
-						putToColumnWidthMap(h, l)
-					}
-					formatAll()
-				}
+			} else if handleBoundKey(ev) {
 				sendRedrawRequest()
 			}
 		case termbox.EventResize:
@@ -802,6 +955,20 @@ loop:
 	}
 }
 
+// setStatusMessage shows a message in the status bar until the next one.
+func setStatusMessage(format string, a ...interface{}) {
+	statusMutex.Lock()
+	statusMessage = fmt.Sprintf(format, a...)
+	statusMutex.Unlock()
+	sendRedrawRequest()
+}
+
+func getStatusMessage() string {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	return statusMessage
+}
+
 func sendRedrawRequest() {
 	redrawRequestChannel <- true
 }
@@ -813,18 +980,31 @@ func redrawRoutine() {
 			<-redrawRequestChannel
 		}
 		redrawMutex.Lock()
-		redraw()
+		if !suspended {
+			redraw()
+		}
 		redrawMutex.Unlock()
 	}
 }
 
-func runCli() {
+func initTermbox() error {
 	err := termbox.Init()
+	if err != nil {
+		return err
+	}
+	termbox.SetOutputMode(termbox.Output256)
+	if hasAltBindings() {
+		termbox.SetInputMode(termbox.InputEsc | termbox.InputAlt)
+	}
+	return nil
+}
+
+func runCli() {
+	err := initTermbox()
 	if err != nil {
 		panic(err)
 	}
 	defer termbox.Close()
-	termbox.SetOutputMode(termbox.Output256)
 	sendRedrawRequest()
 	keyLoop()
 }
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// collector gathers the status values of a machine. command is run on
+	// the remote machine followed by the health backend command, populate
+	// parses the output of both.
+	collector interface {
+		name() string
+		command(m *machine) string
+		populate(m *machine, output string)
+	}
+
+	// shellCollector runs the classic pipelines of GNU tools.
+	shellCollector struct{}
+
+	// procCollector only reads files from /proc with cat, everything is
+	// parsed here. Usage of file systems is not in /proc, it is read from
+	// the POSIX output of df.
+	procCollector struct{}
+)
+
+const (
+	sectionMarker = "==> "
+	healthSection = "health"
+)
+
+var (
+	collectors = map[string]collector{
+		"shell":   shellCollector{},
+		"proc":    procCollector{},
+		"freebsd": bsdCollector{},
+		"darwin":  bsdCollector{darwin: true},
+	}
+
+	// osCollectors are used instead of the default collector on machines
+	// whose uname is not Linux.
+	osCollectors = map[string]string{
+		"FreeBSD": "freebsd",
+		"Darwin":  "darwin",
+	}
+
+	procFiles = []string{
+		"/proc/loadavg", "/proc/meminfo", "/proc/stat", "/proc/uptime",
+		"/proc/net/tcp", "/proc/net/tcp6", "/proc/net/dev", "/proc/diskstats",
+		"/proc/pressure/cpu", "/proc/pressure/memory", "/proc/pressure/io",
+		"/proc/mounts",
+	}
+
+	// tcpStates maps the hex states of /proc/net/tcp to their names.
+	tcpStates = map[string]string{
+		"01": "ESTABLISHED", "02": "SYN_SENT", "03": "SYN_RECV", "04": "FIN_WAIT1",
+		"05": "FIN_WAIT2", "06": "TIME_WAIT", "07": "CLOSE", "08": "CLOSE_WAIT",
+		"09": "LAST_ACK", "0A": "LISTEN", "0B": "CLOSING",
+	}
+
+	ignoredFileSystems = []string{"tmpfs", "devtmpfs", "none"}
+)
+
+func getCollector(m *machine) collector {
+	name := m.Collector
+	if len(name) == 0 {
+		name = *collectorName
+		if c, ok := osCollectors[m.OS]; ok {
+			name = c
+		}
+	}
+	if c, ok := collectors[name]; ok {
+		return c
+	}
+	return collectors["shell"]
+}
+
+// checkCollector fails on a collector name of the machine or of -collector
+// that is not known.
+func checkCollector(m *machine) error {
+	name := m.Collector
+	if len(name) == 0 {
+		name = *collectorName
+	}
+	if _, ok := collectors[name]; !ok {
+		return fmt.Errorf("%s: unknown collector '%s'", m.Name, name)
+	}
+	return nil
+}
+
+func (shellCollector) name() string { return "shell" }
+
+func (shellCollector) command(m *machine) string {
+	return command
+}
+
+func (shellCollector) populate(m *machine, output string) {
+	populate(m, output)
+}
+
+func (procCollector) name() string { return "proc" }
+
+func (procCollector) command(m *machine) string {
+	var b strings.Builder
+	b.WriteString("for f in " + strings.Join(procFiles, " ") + `; do echo "` + sectionMarker + `$f"; cat $f 2>/dev/null; done; `)
+	b.WriteString(`echo "` + sectionMarker + `df"; df -P 2>/dev/null; `)
+	b.WriteString(`echo "` + sectionMarker + `df -i"; df -Pi 2>/dev/null; `)
+	b.WriteString(`echo "` + sectionMarker + healthSection + `"`)
+	return b.String()
+}
+
+// populate turns the files into the lines of the shell collector, so both
+// share the parsing of the values.
+func (procCollector) populate(m *machine, output string) {
+	sections := splitSections(output)
+	mounts := parseMounts(sections["/proc/mounts"])
+	lines := []string{
+		strings.Join(firstFields(sections["/proc/loadavg"], 3), " "),
+		procMemInfo(sections["/proc/meminfo"]),
+		procConnections(sections["/proc/net/tcp"], sections["/proc/net/tcp6"]),
+		procNproc(sections["/proc/stat"]),
+		procUsages(sections["df"], mounts),
+		procUsages(sections["df -i"], mounts),
+		strings.Join(firstFields(sections["/proc/uptime"], 1), " "),
+		firstLine(sections["/proc/stat"]),
+		"-",
+		procPressure(sections),
+		procDiskStats(sections["/proc/diskstats"]),
+		procNetDev(sections["/proc/net/dev"]),
+		sections[healthSection],
+	}
+	populate(m, strings.Join(lines, "\n"))
+}
+
+// splitSections splits the output at the "==> name" lines.
+func splitSections(output string) map[string]string {
+	sections := make(map[string]string)
+	name := ""
+	var content []string
+	flush := func() {
+		if len(name) > 0 {
+			sections[name] = strings.Join(content, "\n")
+		}
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, sectionMarker) {
+			flush()
+			name = strings.TrimPrefix(line, sectionMarker)
+			content = nil
+			continue
+		}
+		content = append(content, line)
+	}
+	flush()
+	return sections
+}
+
+func firstLine(text string) string {
+	return strings.SplitN(text, "\n", 2)[0]
+}
+
+func firstFields(text string, n int) []string {
+	fields := strings.Fields(firstLine(text))
+	if len(fields) > n {
+		fields = fields[:n]
+	}
+	return fields
+}
+
+func procMemInfo(text string) string {
+	var pairs []string
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 {
+			pairs = append(pairs, fields[0]+fields[1])
+		}
+	}
+	return strings.Join(pairs, " ")
+}
+
+func procNproc(text string) string {
+	n := 0
+	for _, line := range strings.Split(text, "\n") {
+		if len(line) > 3 && strings.HasPrefix(line, "cpu") && line[3] >= '0' && line[3] <= '9' {
+			n++
+		}
+	}
+	return strconv.Itoa(n)
+}
+
+func procPressure(sections map[string]string) string {
+	var parts []string
+	for _, r := range pressureResources {
+		if line := firstLine(sections["/proc/pressure/"+r]); len(line) > 0 {
+			parts = append(parts, r+": "+line)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// procDiskStats picks the device name and the read and write counters.
+func procDiskStats(text string) string {
+	var parts []string
+	for _, line := range strings.Split(text, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 10 {
+			continue
+		}
+		parts = append(parts, strings.Join([]string{f[2], f[3], f[5], f[7], f[9]}, ":"))
+	}
+	return strings.Join(parts, " ")
+}
+
+// procNetDev picks the interface name and the byte and error counters.
+func procNetDev(text string) string {
+	var parts []string
+	for _, line := range strings.Split(text, "\n") {
+		if !strings.Contains(line, ":") {
+			continue
+		}
+		f := strings.Fields(strings.Replace(line, ":", " ", 1))
+		if len(f) < 12 {
+			continue
+		}
+		parts = append(parts, strings.Join([]string{f[0], f[1], f[3], f[9], f[11]}, ":"))
+	}
+	return strings.Join(parts, " ")
+}
+
+// procConnections counts the sockets of /proc/net/tcp{,6} per state and
+// collects the listening ports and the remote peers.
+func procConnections(texts ...string) string {
+	states := make(map[string]int)
+	ports := make(map[int64]bool)
+	peers := make(map[string]int)
+	for _, text := range texts {
+		for _, line := range strings.Split(text, "\n") {
+			f := strings.Fields(line)
+			if len(f) < 4 {
+				continue
+			}
+			state, ok := tcpStates[strings.ToUpper(f[3])]
+			if !ok {
+				continue
+			}
+			states[state]++
+			if state == listenState {
+				if _, port, err := parseProcAddress(f[1]); err == nil {
+					ports[port] = true
+				}
+			} else if ip, _, err := parseProcAddress(f[2]); err == nil {
+				peers[ip]++
+			}
+		}
+	}
+	return connectionsLine(states, ports, peers)
+}
+
+// connectionsLine formats the counts like the output of connsCmd.
+func connectionsLine(states map[string]int, ports map[int64]bool, peers map[string]int) string {
+	if len(states) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for s, n := range states {
+		fmt.Fprintf(&b, "%s=%d ", s, n)
+	}
+	b.WriteString(listenPorts + "=")
+	for p := range ports {
+		fmt.Fprintf(&b, "%d,", p)
+	}
+	b.WriteString(" " + peersKey + "=")
+	for p, n := range peers {
+		fmt.Fprintf(&b, "%s/%d,", p, n)
+	}
+	return b.String()
+}
+
+// parseProcAddress decodes the hex "address:port" of /proc/net/tcp, the
+// address is in host byte order by 32 bit words.
+func parseProcAddress(s string) (string, int64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 || (len(parts[0]) != 8 && len(parts[0]) != 32) {
+		return "", 0, fmt.Errorf("invalid address '%s'", s)
+	}
+	port, err := strconv.ParseInt(parts[1], 16, 64)
+	if err != nil {
+		return "", 0, err
+	}
+	ip := make(net.IP, len(parts[0])/2)
+	for i := 0; i < len(parts[0]); i += 8 {
+		word, err := strconv.ParseUint(parts[0][i:i+8], 16, 32)
+		if err != nil {
+			return "", 0, err
+		}
+		for j := 0; j < 4; j++ {
+			ip[i/2+j] = byte(word >> (8 * uint(j)))
+		}
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	return ip.String(), port, nil
+}
+
+// parseMounts maps mount points to their file system types.
+func parseMounts(text string) map[string]string {
+	mounts := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		f := strings.Fields(line)
+		if len(f) >= 3 {
+			mounts[f[1]] = f[2]
+		}
+	}
+	return mounts
+}
+
+// procUsages turns the POSIX df output into "mount=use%" fields, skipping
+// the file systems the shell collector excludes with -x.
+func procUsages(text string, mounts map[string]string) string {
+	var usages []string
+	for _, line := range strings.Split(text, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 6 || !strings.HasSuffix(f[4], "%") || !strings.HasPrefix(f[5], "/") {
+			continue
+		}
+		if contains(ignoredFileSystems, mounts[f[5]]) {
+			continue
+		}
+		usages = append(usages, f[5]+"="+f[4])
+	}
+	sort.Strings(usages)
+	return strings.Join(usages, " ")
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/nsf/termbox-go"
+	"golang.org/x/crypto/ssh"
+	sshterm "golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	consoleTerminal   = "terminal"
+	consoleEmbedded   = "embedded"
+	consoleTmuxWindow = "tmux-window"
+	consoleTmuxPane   = "tmux-pane"
+
+	tmuxWindowTemplate = "tmux new-window -n {name} {ssh}"
+	tmuxPaneTemplate   = "tmux split-window {ssh}"
+)
+
+// openConsole opens a shell to the selected machine, running command first if
+// it is not empty. Where the shell is opened depends on -console.
+func openConsole(command string) {
+	m := getSelectedMachine()
+	if len(strings.TrimSpace(command)) > 0 {
+		command = fmt.Sprintf("%s; bash -l", command)
+	}
+	switch *consoleMode {
+	case consoleEmbedded:
+		if err := openEmbeddedConsole(m, command); err != nil {
+			setStatusMessage("%s: %s", m.Name, err.Error())
+		}
+		sendRedrawRequest()
+	case consoleTmuxWindow:
+		launch(tmuxWindowTemplate, m, command)
+	case consoleTmuxPane:
+		launch(tmuxPaneTemplate, m, command)
+	default:
+		launch(*launchTemplate, m, command)
+	}
+}
+
+func launch(template string, m *machine, command string) {
+	args := expandTemplate(template, m, command)
+	if len(args) == 0 {
+		setStatusMessage("empty launch template")
+		return
+	}
+	runConsoleCommand(exec.Command(args[0], args[1:]...))
+}
+
+// expandTemplate splits the template into arguments and replaces the
+// placeholders in them. {ssh} is replaced by the arguments of the ssh
+// template. An argument that is only a placeholder with an empty value is
+// left out together with the option before it, so "-J {jump}" disappears
+// for machines without a jump host.
+func expandTemplate(template string, m *machine, command string) []string {
+	term := *terminal
+	if len(term) == 0 {
+		term = "urxvt"
+	}
+	values := map[string]string{
+		"{name}": m.Name,
+		"{host}": m.Host,
+		"{user}": m.User,
+		"{port}": m.Port,
+		"{jump}": m.Jump,
+		"{cmd}":  command,
+		"{term}": term,
+	}
+	// a single pass, so that a value containing a placeholder, e.g. a
+	// command with {name}, is not replaced again
+	var pairs []string
+	for k, v := range values {
+		pairs = append(pairs, k, v)
+	}
+	replacer := strings.NewReplacer(pairs...)
+	var args []string
+	for _, arg := range splitArgs(template) {
+		if arg == "{ssh}" {
+			args = append(args, expandTemplate(*sshTemplate, m, command)...)
+			continue
+		}
+		if v, ok := values[arg]; ok && len(v) == 0 {
+			if len(args) > 0 && strings.HasPrefix(args[len(args)-1], "-") && arg != "{cmd}" {
+				args = args[:len(args)-1]
+			}
+			continue
+		}
+		args = append(args, replacer.Replace(arg))
+	}
+	return args
+}
+
+// splitArgs splits s on white space, keeping quoted parts together.
+func splitArgs(s string) []string {
+	var args []string
+	var current []rune
+	var quote rune
+	inArg := false
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current = append(current, r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, string(current))
+				current = current[:0]
+				inArg = false
+			}
+		default:
+			current = append(current, r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, string(current))
+	}
+	return args
+}
+
+func runConsoleCommand(cmd *exec.Cmd) {
+	go func() {
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			setStatusMessage("%s: %s %s", cmd.Args[0], err.Error(), strings.TrimSpace(string(out)))
+		}
+	}()
+}
+
+// openEmbeddedConsole suspends the dashboard and runs an interactive session
+// over the machine's ssh client in the current terminal. Redraw requests are
+// dropped until the session ends.
+func openEmbeddedConsole(m *machine, command string) error {
+	client, err := getMachineClient(m, false)
+	if err != nil {
+		return err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		dropClient(m, client)
+		return err
+	}
+	defer session.Close()
+
+	redrawMutex.Lock()
+	suspended = true
+	termbox.Close()
+	redrawMutex.Unlock()
+	defer func() {
+		redrawMutex.Lock()
+		defer redrawMutex.Unlock()
+		if err := initTermbox(); err != nil {
+			panic(err)
+		}
+		suspended = false
+	}()
+
+	fd := int(os.Stdin.Fd())
+	state, err := sshterm.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer sshterm.Restore(fd, state)
+
+	w, h, err := sshterm.GetSize(fd)
+	if err != nil {
+		w, h = 80, 24
+	}
+	term := os.Getenv("TERM")
+	if len(term) == 0 {
+		term = "xterm-256color"
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty(term, h, w, modes); err != nil {
+		return err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	// the input is read from a terminal of its own, closing it ends the
+	// copy so that no keys are taken from the dashboard afterwards
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := tty.Read(buf)
+			if n > 0 {
+				if _, err := stdin.Write(buf[:n]); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	done := make(chan bool)
+	defer close(done)
+	resize := make(chan os.Signal, 1)
+	signal.Notify(resize, syscall.SIGWINCH)
+	defer signal.Stop(resize)
+	go func() {
+		for {
+			select {
+			case <-resize:
+				if w, h, err := sshterm.GetSize(fd); err == nil {
+					session.WindowChange(h, w)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	if len(command) > 0 {
+		err = session.Start(command)
+	} else {
+		err = session.Shell()
+	}
+	if err != nil {
+		return err
+	}
+	err = session.Wait()
+	if _, ok := err.(*ssh.ExitError); ok {
+		return nil
+	}
+	return err
+}
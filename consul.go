@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+type consulBackend struct{}
+
+func (consulBackend) name() string {
+	return "consul"
+}
+
+// command builds the request for the health checks of the machine's own
+// Consul node.
+func (consulBackend) command(m *machine) string {
+	address := *consulAgent
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	query := url.Values{}
+	if len(*consulDC) > 0 {
+		query.Set("dc", *consulDC)
+	}
+	u := strings.TrimRight(address, "/") + "/v1/health/node/"
+	cmd := "curl -s"
+	if len(*consulToken) > 0 {
+		cmd += " -H " + shellQuote("X-Consul-Token: "+*consulToken)
+	}
+	cmd += ` "` + u + `$(hostname)`
+	if len(query) > 0 {
+		cmd += "?" + query.Encode()
+	}
+	return cmd + `"`
+}
+
+func (consulBackend) parse(m *machine, output string) []healthCheck {
+	var checks []healthCheck
+	err := json.Unmarshal([]byte(strings.TrimSpace(output)), &checks)
+	if err != nil {
+		return nil
+	}
+	return checks
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+type (
+	container struct {
+		Name     string
+		Status   string
+		State    string
+		ExitCode int
+	}
+
+	// containerUsage is the CPU and memory usage of a container, it is only
+	// fetched while the detail view of its machine is open.
+	containerUsage struct {
+		CPU        float32
+		Memory     string
+		MemPercent float32
+	}
+)
+
+const (
+	containerRunning    = "running"
+	containerUnhealthy  = "unhealthy"
+	containerStarting   = "starting"
+	containerRestarting = "restarting"
+	containerExited     = "exited"
+	containerOther      = "other"
+
+	containersMarker = sectionMarker + "containers"
+
+	// containersCmd prints the containers on one line, or "-" when there is
+	// no docker or podman or it cannot be used.
+	containersCmd = `c=; if command -v docker >/dev/null; then c=docker; elif command -v podman >/dev/null; then c=podman; fi; ` +
+		`if [ -n "$c" ] && out=$($c ps -a --format '{{.Names}}|{{.Status}}' 2>/dev/null); then ` +
+		`echo "$out" | tr '\n' ';'; echo; else echo "-"; fi`
+
+	containerStatsCmd = `c=podman; if command -v docker >/dev/null; then c=docker; fi; ` +
+		`$c stats --no-stream --format '{{.Name}}|{{.CPUPerc}}|{{.MemUsage}}|{{.MemPerc}}'`
+	containerStatsDelay = 5 * time.Second
+)
+
+var (
+	// containerUsages holds the usage of the containers per machine while
+	// its detail view is open.
+	containerUsages = make(map[string]map[string]containerUsage)
+	usageMutex      sync.Mutex
+)
+
+func containersCommand() string {
+	return `echo "` + containersMarker + `" && ` + containersCmd
+}
+
+// parseContainerStatus derives the state and the exit code (-1 unless
+// exited) from the status text of docker ps, e.g. "Up 2 hours (unhealthy)"
+// or "Exited (1) 3 minutes ago". The containers column and the docker health
+// backend both use it.
+func parseContainerStatus(status string) (string, int) {
+	switch {
+	case strings.Contains(status, "(unhealthy)"):
+		return containerUnhealthy, -1
+	case strings.Contains(status, "(health: starting)"):
+		return containerStarting, -1
+	case strings.HasPrefix(status, "Up"):
+		return containerRunning, -1
+	case strings.HasPrefix(status, "Restarting"):
+		return containerRestarting, -1
+	case dockerExitPat.MatchString(status):
+		code, _ := strconv.Atoi(dockerExitPat.FindStringSubmatch(status)[1])
+		return containerExited, code
+	}
+	return containerOther, -1
+}
+
+// populateContainers takes the container lines out of the output and
+// returns the rest for the collector.
+func populateContainers(m *machine, output string) string {
+	lines := strings.Split(output, "\n")
+	idx := -1
+	for i, l := range lines {
+		if l == containersMarker {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 || idx+1 >= len(lines) {
+		return output
+	}
+	ps := lines[idx+1]
+	rest := append(append([]string{}, lines[:idx]...), lines[idx+2:]...)
+	m.ContainerList = nil
+	m.Unhealthy.Value = nil
+	m.Restarting.Value = nil
+	if strings.TrimSpace(ps) == "-" {
+		return strings.Join(rest, "\n")
+	}
+	unhealthy, restarting := int32(0), int32(0)
+	m.ContainerList = []container{}
+	for _, p := range strings.Split(ps, ";") {
+		f := strings.SplitN(p, "|", 2)
+		if len(f) != 2 {
+			continue
+		}
+		c := container{Name: f[0], Status: f[1]}
+		c.State, c.ExitCode = parseContainerStatus(f[1])
+		switch c.State {
+		case containerUnhealthy:
+			unhealthy++
+		case containerRestarting:
+			restarting++
+		}
+		m.ContainerList = append(m.ContainerList, c)
+	}
+	sort.Slice(m.ContainerList, func(i, j int) bool { return m.ContainerList[i].Name < m.ContainerList[j].Name })
+	m.Unhealthy.Value = unhealthy
+	m.Restarting.Value = restarting
+	return strings.Join(rest, "\n")
+}
+
+// containerStatsRoutine fetches the usage of the containers of a machine
+// until done is closed, the way the process view refreshes its list.
+func containerStatsRoutine(m *machine, done chan bool) {
+	defer func() {
+		usageMutex.Lock()
+		delete(containerUsages, m.Name)
+		usageMutex.Unlock()
+	}()
+	for {
+		if len(m.ContainerList) > 0 {
+			output, err := runOnMachine(m, containerStatsCmd)
+			if err == nil {
+				usageMutex.Lock()
+				containerUsages[m.Name] = parseContainerStats(output)
+				usageMutex.Unlock()
+				sendRedrawRequest()
+			}
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(containerStatsDelay):
+		}
+	}
+}
+
+func parseContainerStats(output string) map[string]containerUsage {
+	usage := make(map[string]containerUsage)
+	for _, line := range strings.Split(output, "\n") {
+		f := strings.Split(strings.TrimSpace(line), "|")
+		if len(f) != 4 {
+			continue
+		}
+		cpu, _ := strconv.ParseFloat(strings.TrimSuffix(f[1], "%"), 32)
+		mem, _ := strconv.ParseFloat(strings.TrimSuffix(f[3], "%"), 32)
+		usage[f[0]] = containerUsage{CPU: float32(cpu), Memory: f[2], MemPercent: float32(mem)}
+	}
+	return usage
+}
+
+// countContainers returns the running (including unhealthy and starting),
+// exited and unhealthy counts.
+func countContainers(m *machine) (running, exited, unhealthy int) {
+	for _, c := range m.ContainerList {
+		switch c.State {
+		case containerRunning, containerStarting:
+			running++
+		case containerUnhealthy:
+			running++
+			unhealthy++
+		case containerExited:
+			exited++
+		}
+	}
+	return
+}
+
+func getCountStatus(value measurement, defaultWarn, defaultErr float64) int {
+	v, ok := value.Value.(int32)
+	if !ok {
+		return statusOK
+	}
+	warn, ok := value.Warning.(float64)
+	if !ok {
+		warn = defaultWarn
+	}
+	err, ok := value.Error.(float64)
+	if !ok {
+		err = defaultErr
+	}
+	if v < int32(warn) {
+		return statusOK
+	} else if v < int32(err) {
+		return statusWarning
+	}
+	return statusError
+}
+
+func getUnhealthyStatus(machine *machine) int {
+	return getCountStatus(machine.Unhealthy, 1, 1)
+}
+
+func getRestartingStatus(machine *machine) int {
+	return getCountStatus(machine.Restarting, 1, 3)
+}
+
+func getContainersStatus(machine *machine) int {
+	return getUnhealthyStatus(machine) | getRestartingStatus(machine)
+}
+
+func containerLines(m *machine) []styledText {
+	var lines []styledText
+	if len(m.ContainerList) == 0 {
+		return lines
+	}
+	usageMutex.Lock()
+	usage := containerUsages[m.Name]
+	usageMutex.Unlock()
+	lines = append(lines, sectionLine("Containers"))
+	lines = append(lines, textLine(fmt.Sprintf("    %-30s %6s %-22s %s", "name", "%CPU", "memory", "status"), 9))
+	for _, c := range m.ContainerList {
+		fg := termbox.Attribute(termbox.ColorDefault)
+		switch c.State {
+		case containerUnhealthy:
+			fg = statusColor(getUnhealthyStatus(m))
+		case containerRestarting:
+			fg = statusColor(getRestartingStatus(m))
+		case containerExited, containerOther:
+			fg = 9
+		}
+		u := fmt.Sprintf("%6s %-22s", "", "")
+		if cu, ok := usage[c.Name]; ok {
+			u = fmt.Sprintf("%6.1f %-22s", cu.CPU, cu.Memory)
+		}
+		lines = append(lines, textLine(fmt.Sprintf("    %-30s %s %s", c.Name, u, c.Status), fg))
+	}
+	return lines
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// cpuTimes is the share of CPU time in percent spent in each state since the
+// previous sample.
+type cpuTimes struct {
+	User   float32
+	System float32
+	IOWait float32
+	Steal  float32
+	Idle   float32
+}
+
+const (
+	statUser = iota
+	statNice
+	statSystem
+	statIdle
+	statIOWait
+	statIRQ
+	statSoftIRQ
+	statSteal
+)
+
+// populateCPU parses the cpu line of /proc/stat. Utilization is computed from
+// the difference to the previous sample of the machine, the first sample
+// gives the average since boot.
+func populateCPU(m *machine, line string) {
+	fields := strings.Fields(line)
+	if len(fields) < statSteal+2 || fields[0] != "cpu" {
+		m.CPU.Value = float32(-1)
+		return
+	}
+	sample := make([]uint64, statSteal+1)
+	for i := range sample {
+		v, err := strconv.ParseUint(fields[i+1], 10, 64)
+		if err != nil {
+			m.CPU.Value = float32(-1)
+			return
+		}
+		sample[i] = v
+	}
+	delta := make([]uint64, len(sample))
+	copy(delta, sample)
+	if len(m.cpuSample) == len(sample) {
+		for i := range sample {
+			if sample[i] < m.cpuSample[i] {
+				// counters were reset, the machine has rebooted
+				copy(delta, sample)
+				break
+			}
+			delta[i] = sample[i] - m.cpuSample[i]
+		}
+	}
+	m.cpuSample = sample
+	total := uint64(0)
+	for _, v := range delta {
+		total += v
+	}
+	if total == 0 {
+		return
+	}
+	percent := func(v uint64) float32 {
+		return float32(v) * 100 / float32(total)
+	}
+	m.CPUTimes = cpuTimes{
+		User:   percent(delta[statUser] + delta[statNice]),
+		System: percent(delta[statSystem] + delta[statIRQ] + delta[statSoftIRQ]),
+		IOWait: percent(delta[statIOWait]),
+		Steal:  percent(delta[statSteal]),
+		Idle:   percent(delta[statIdle]),
+	}
+	m.CPU.Value = m.CPUTimes.User + m.CPUTimes.System
+	m.IOWait.Value = m.CPUTimes.IOWait
+	m.Steal.Value = m.CPUTimes.Steal
+}
+
+// getValueStatus compares a float32 measurement against its limits, missing
+// values are OK.
+func getValueStatus(value measurement, defaultWarn, defaultErr float64) int {
+	v, ok := value.Value.(float32)
+	if !ok {
+		return statusOK
+	}
+	warn, ok := value.Warning.(float64)
+	if !ok {
+		warn = defaultWarn
+	}
+	err, ok := value.Error.(float64)
+	if !ok {
+		err = defaultErr
+	}
+	if v < float32(warn) {
+		return statusOK
+	} else if v < float32(err) {
+		return statusWarning
+	}
+	return statusError
+}
+
+func getIOWaitStatus(machine *machine) int {
+	return getValueStatus(machine.IOWait, 20, 40)
+}
+
+func getStealStatus(machine *machine) int {
+	return getValueStatus(machine.Steal, 10, 25)
+}
+
+func cpuLines(m *machine) []styledText {
+	var lines []styledText
+	if m.CPUTimes == (cpuTimes{}) {
+		return lines
+	}
+	lines = append(lines, sectionLine("CPU"))
+	rows := []struct {
+		name   string
+		value  float32
+		status int
+	}{
+		{"user", m.CPUTimes.User, statusOK},
+		{"system", m.CPUTimes.System, statusOK},
+		{"iowait", m.CPUTimes.IOWait, getIOWaitStatus(m)},
+		{"steal", m.CPUTimes.Steal, getStealStatus(m)},
+		{"idle", m.CPUTimes.Idle, statusOK},
+	}
+	for _, r := range rows {
+		fg := termbox.Attribute(termbox.ColorDefault)
+		if r.status != statusOK {
+			fg = statusColor(r.status)
+		}
+		lines = append(lines, textLine(fmt.Sprintf("    %-8s %5.1f%%", r.name, r.value), fg))
+	}
+	return lines
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+func textLine(text string, fg termbox.Attribute) styledText {
+	s := newStyledText()
+	for _, r := range text {
+		s.Runes = append(s.Runes, r)
+		s.FG = append(s.FG, fg)
+		s.BG = append(s.BG, termbox.ColorDefault)
+	}
+	return s
+}
+
+func appendStyled(s *styledText, t styledText) {
+	s.Runes = append(s.Runes, t.Runes...)
+	s.FG = append(s.FG, t.FG...)
+	s.BG = append(s.BG, t.BG...)
+}
+
+func sectionLine(title string) styledText {
+	return textLine(title, termbox.ColorDefault|termbox.AttrBold)
+}
+
+func checkStatusColor(status string) termbox.Attribute {
+	switch status {
+	case checkPassing:
+		return 3
+	case checkWarning:
+		return 4 | termbox.AttrBold
+	case checkUnknown:
+		return 9 | termbox.AttrBold
+	}
+	return 2 | termbox.AttrBold
+}
+
+func statusColor(status int) termbox.Attribute {
+	if status&statusError > 0 {
+		return 2 | termbox.AttrBold
+	} else if status&statusWarning > 0 {
+		return 4 | termbox.AttrBold
+	} else if status&statusUnknown > 0 {
+		return 9 | termbox.AttrBold
+	}
+	return 3
+}
+
+// getDetailLines collects everything known about a machine for the detail
+// view, every section is separated by an empty line.
+func getDetailLines(m *machine) []styledText {
+	lines := []styledText{
+		textLine(fmt.Sprintf("host:   %s@%s:%s", m.User, m.Host, m.Port), termbox.ColorDefault),
+	}
+	if len(m.OS) > 0 {
+		lines = append(lines, textLine(fmt.Sprintf("os:     %s %s (%s collector)", m.OS, m.Kernel, getCollector(m).name()), termbox.ColorDefault))
+	}
+	if len(m.Groups) > 0 {
+		lines = append(lines, textLine("groups: "+strings.Join(m.Groups, ", "), termbox.ColorDefault))
+	}
+	if m.Fetching {
+		lines = append(lines, textLine("fetching...", termbox.ColorGreen|termbox.AttrBold))
+	}
+	if !m.GotResult && len(m.FetchingError) > 0 {
+		lines = append(lines, textLine("error:  "+m.FetchingError, termbox.ColorRed))
+	}
+	sections := [][]styledText{
+		maintenanceLines(m),
+		ackLines(m),
+		kubeLines(m),
+		historyLines(m),
+		cpuLines(m),
+		memoryLines(m),
+		rateLines(m),
+		connectionLines(m),
+		containerLines(m),
+		checkLines(m),
+		failedUnitLines(m),
+	}
+	for _, section := range sections {
+		if len(section) > 0 {
+			lines = append(lines, newStyledText())
+			lines = append(lines, section...)
+		}
+	}
+	return lines
+}
+
+func openDetailView() {
+	name := getSelectedMachine().Name
+	done := make(chan bool)
+	openPane(&pane{
+		title: func() styledText {
+			m := machines[name]
+			return textLine(m.Name, statusColor(m.Status))
+		},
+		lines: func() []styledText {
+			return getDetailLines(machines[name])
+		},
+		hint: "Esc: back  ctrl+r: reload",
+		onKey: func(ev termbox.Event) bool {
+			if ev.Key == termbox.KeyCtrlR {
+				refreshMachine(machines[name])
+				return true
+			}
+			if ev.Ch == 'd' {
+				closePane()
+				return true
+			}
+			return false
+		},
+		onClose: func() { close(done) },
+	})
+	go containerStatsRoutine(machines[name], done)
+}
+
+func failedUnitLines(m *machine) []styledText {
+	var lines []styledText
+	units, ok := m.FailedUnits.Value.([]string)
+	if !ok || len(units) == 0 {
+		return lines
+	}
+	lines = append(lines, sectionLine("Failed units"))
+	fg := statusColor(getFailedUnitsStatus(m))
+	for _, u := range units {
+		if isIgnored(u, m.FailedUnits.Ignore) {
+			lines = append(lines, textLine("    "+u+" (ignored)", 9))
+		} else {
+			lines = append(lines, textLine("    "+u, fg))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+type (
+	// event is one entry of the event log: a status change, fetch error,
+	// host key problem or reconnect of a machine.
+	event struct {
+		Time    time.Time `json:"t"`
+		Machine string    `json:"machine"`
+		Level   int       `json:"level"`
+		Kind    string    `json:"kind"`
+		Text    string    `json:"text"`
+	}
+
+	// eventView is the state of the open event log pane.
+	eventView struct {
+		machine  string
+		minLevel int
+	}
+)
+
+const (
+	maxEvents = 1000
+	eventHint = "Esc: back  /: filter  l: minimum level  s: selected machine only"
+)
+
+var (
+	events     []event
+	eventMutex sync.Mutex
+	eventLog   jsonLines
+
+	levelNames = map[int]string{statusOK: "info", statusWarning: "warning", statusError: "error"}
+)
+
+// statusName names the status of a machine as shown in the event log.
+func statusName(status int) string {
+	switch {
+	case status&statusUnknown > 0:
+		return "unreachable"
+	case status == 0:
+		return ""
+	case status&statusError > 0:
+		return "error"
+	case status&statusWarning > 0:
+		return "warning"
+	}
+	return "ok"
+}
+
+func eventKind(err error) string {
+	msg := err.Error()
+	if strings.Contains(msg, "knownhosts") || strings.Contains(msg, "host key") {
+		return "host key"
+	}
+	return "fetch"
+}
+
+// loadEvents reads the last events of the -events file, the file is cut
+// down to them if it holds more.
+func loadEvents() error {
+	if len(*eventFile) == 0 {
+		return nil
+	}
+	eventLog.mutex.Lock()
+	defer eventLog.mutex.Unlock()
+	path := expandHome(*eventFile)
+	err := eventLog.load(path, func(line []byte) {
+		var e event
+		if err := json.Unmarshal(line, &e); err != nil {
+			return
+		}
+		events = append(events, e)
+		if len(events) > maxEvents {
+			events = events[1:]
+		}
+	})
+	if err != nil || eventLog.lines == len(events) {
+		return err
+	}
+	return eventLog.rewrite(path, eventRecords())
+}
+
+func eventRecords() []interface{} {
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+	records := make([]interface{}, len(events))
+	for i, e := range events {
+		records[i] = e
+	}
+	return records
+}
+
+// logEvent adds an event to the log and appends it to the -events file. The
+// file lock is taken first so that events reach the file in the order of the
+// log, the file is written without holding eventMutex, which the event pane
+// takes on every redraw.
+func logEvent(m *machine, level int, kind, text string) {
+	e := event{Time: time.Now(), Machine: m.Name, Level: level, Kind: kind, Text: text}
+	eventLog.mutex.Lock()
+	defer eventLog.mutex.Unlock()
+	eventMutex.Lock()
+	events = append(events, e)
+	if len(events) > maxEvents {
+		events = events[1:]
+	}
+	keep := len(events)
+	eventMutex.Unlock()
+	if len(*eventFile) == 0 {
+		return
+	}
+	if err := eventLog.append(expandHome(*eventFile), e, keep, eventRecords); err != nil {
+		setStatusMessage("events: %s", err.Error())
+	}
+}
+
+// logFetch logs the outcome of a fetch: errors when their text changes and
+// status changes. prevStatus and prevError are the values before the fetch.
+func logFetch(m *machine, prevStatus int, prevError string, err error) {
+	if err != nil {
+		if err.Error() != prevError {
+			logEvent(m, statusError, eventKind(err), err.Error())
+		}
+		return
+	}
+	prev, cur := statusName(prevStatus), statusName(m.Status)
+	if prev == cur || (len(prev) == 0 && cur == "ok") {
+		return
+	}
+	text := cur
+	if len(prev) > 0 {
+		text = prev + " -> " + cur
+	}
+	logEvent(m, worstStatus(m.Status), "status", text)
+}
+
+func openEvents(text string) {
+	v := &eventView{machine: text, minLevel: statusOK}
+	openPane(&pane{
+		title: func() styledText {
+			title := "event log"
+			if len(v.machine) > 0 {
+				title += " of " + v.machine
+			}
+			if v.minLevel != statusOK {
+				title += ", " + levelNames[v.minLevel] + " and worse"
+			}
+			return textLine(title, termbox.ColorDefault|termbox.AttrBold)
+		},
+		lines:      v.lines,
+		hint:       eventHint,
+		follow:     true,
+		searchable: true,
+		onKey:      v.handleKey,
+	})
+}
+
+func (v *eventView) lines() []styledText {
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+	var lines []styledText
+	for _, e := range events {
+		if e.Level < v.minLevel || (len(v.machine) > 0 && e.Machine != v.machine) {
+			continue
+		}
+		l := textLine(e.Time.Format("01-02 15:04:05")+" ", 9)
+		appendStyled(&l, textLine(fmt.Sprintf("%-7s ", levelNames[e.Level]), levelColor(e.Level)))
+		appendStyled(&l, textLine(fmt.Sprintf("%s %s: %s", e.Machine, e.Kind, e.Text), termbox.ColorDefault))
+		lines = append(lines, l)
+	}
+	if len(lines) == 0 {
+		lines = append(lines, textLine("no events", 9))
+	}
+	return lines
+}
+
+func levelColor(level int) termbox.Attribute {
+	switch level {
+	case statusError:
+		return 2 | termbox.AttrBold
+	case statusWarning:
+		return 4 | termbox.AttrBold
+	}
+	return 3
+}
+
+func (v *eventView) handleKey(ev termbox.Event) bool {
+	switch ev.Ch {
+	case 'l':
+		v.minLevel <<= 1
+		if v.minLevel > statusError {
+			v.minLevel = statusOK
+		}
+	case 's':
+		if len(v.machine) > 0 {
+			v.machine = ""
+		} else {
+			v.machine = getSelectedMachine().Name
+		}
+	default:
+		return false
+	}
+	return true
+}
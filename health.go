@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+type (
+	// healthBackend feeds the services column. command is run on the remote
+	// machine together with the other status commands and parse turns its
+	// output into checks.
+	healthBackend interface {
+		name() string
+		command(m *machine) string
+		parse(m *machine, output string) []healthCheck
+	}
+
+	healthCheck struct {
+		Name        string `json:"Name"`
+		ServiceName string `json:"ServiceName"`
+		Status      string `json:"Status"`
+		Output      string `json:"Output"`
+		Ignored     bool   `json:"-"`
+	}
+
+	systemdBackend    struct{}
+	dockerBackend     struct{}
+	supervisorBackend struct{}
+	httpBackend       struct{}
+)
+
+const (
+	checkPassing  = "passing"
+	checkUnknown  = "unknown"
+	checkWarning  = "warning"
+	checkCritical = "critical"
+)
+
+var (
+	healthBackends = map[string]healthBackend{
+		"consul":     consulBackend{},
+		"systemd":    systemdBackend{},
+		"docker":     dockerBackend{},
+		"supervisor": supervisorBackend{},
+		"http":       httpBackend{},
+	}
+
+	dockerExitPat = regexp.MustCompile(`^Exited \((\d+)\)`)
+)
+
+func getHealthBackend(m *machine) healthBackend {
+	if b, ok := healthBackends[healthBackendName(m)]; ok {
+		return b
+	}
+	return healthBackends["consul"]
+}
+
+func healthBackendName(m *machine) string {
+	if len(m.Health) > 0 {
+		return m.Health
+	}
+	return *health
+}
+
+// checkHealthBackend fails on a health backend name of the machine or of
+// -health that is not known.
+func checkHealthBackend(m *machine) error {
+	if _, ok := healthBackends[healthBackendName(m)]; !ok {
+		return fmt.Errorf("%s: unknown health backend '%s'", m.Name, healthBackendName(m))
+	}
+	return nil
+}
+
+// healthCommand wraps the backend command so that a missing tool or a failing
+// check does not fail the whole status command.
+func healthCommand(m *machine) string {
+	return "(" + getHealthBackend(m).command(m) + ") 2>/dev/null; true"
+}
+
+func populateChecks(m *machine, output string) {
+	m.Checks = nil
+	m.Services.Value = nil
+	backend := getHealthBackend(m)
+	if len(strings.TrimSpace(output)) == 0 && backend.name() != "docker" {
+		return
+	}
+	checks := backend.parse(m, output)
+	if checks == nil {
+		return
+	}
+	patterns := append([]string{}, m.Services.Ignore...)
+	if backend.name() == "consul" {
+		patterns = append(patterns, strings.Split(*consulIgnore, ",")...)
+	}
+	checkArray := [4]int32{}
+	for i := range checks {
+		if isIgnored(checks[i].Name, patterns) {
+			checks[i].Ignored = true
+			continue
+		}
+		switch checks[i].Status {
+		case checkPassing:
+			checkArray[0]++
+		case checkUnknown:
+			checkArray[1]++
+		case checkWarning:
+			checkArray[2]++
+		case checkCritical:
+			fallthrough
+		default:
+			checkArray[3]++
+		}
+	}
+	m.Checks = checks
+	m.Services.Value = checkArray
+}
+
+func isIgnored(name string, patterns []string) bool {
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if len(p) == 0 {
+			continue
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLines lists the checks grouped by the service they belong to, checks
+// without a service come first.
+func checkLines(m *machine) []styledText {
+	var lines []styledText
+	if len(m.Checks) == 0 {
+		return lines
+	}
+	lines = append(lines, sectionLine(getHealthBackend(m).name()+" checks"))
+	var services []string
+	byService := make(map[string][]healthCheck)
+	for _, c := range m.Checks {
+		if _, ok := byService[c.ServiceName]; !ok {
+			services = append(services, c.ServiceName)
+		}
+		byService[c.ServiceName] = append(byService[c.ServiceName], c)
+	}
+	for _, service := range services {
+		if len(service) > 0 {
+			lines = append(lines, textLine("  "+service, 9|termbox.AttrBold))
+		}
+		for _, c := range byService[service] {
+			label := fmt.Sprintf("    %-8s %s", c.Status, c.Name)
+			fg := checkStatusColor(c.Status)
+			if c.Ignored {
+				label += " (ignored)"
+				fg = 9
+			}
+			lines = append(lines, textLine(label, fg))
+			for _, l := range strings.Split(strings.TrimSpace(c.Output), "\n") {
+				if len(strings.TrimSpace(l)) > 0 {
+					lines = append(lines, textLine("             "+l, 9))
+				}
+			}
+		}
+	}
+	return lines
+}
+
+func (systemdBackend) name() string {
+	return "systemd"
+}
+
+func (systemdBackend) command(m *machine) string {
+	return `systemctl list-units --type=service --all --no-legend --plain`
+}
+
+// parse maps the active state of every loaded service unit, inactive units
+// are left out.
+func (systemdBackend) parse(m *machine, output string) []healthCheck {
+	checks := []healthCheck{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		c := healthCheck{Name: fields[0], Output: strings.Join(fields[4:], " ")}
+		switch fields[2] {
+		case "active":
+			c.Status = checkPassing
+		case "failed":
+			c.Status = checkCritical
+		case "activating", "deactivating", "reloading":
+			c.Status = checkWarning
+		default:
+			continue
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+func (dockerBackend) name() string {
+	return "docker"
+}
+
+// command prints nothing, the containers are already listed on every
+// machine for the containers column and parse uses that list.
+func (dockerBackend) command(m *machine) string {
+	return `true`
+}
+
+// parse uses the container health state when the container has a health
+// check and the running state otherwise. Containers that exited with 0 are
+// considered passing.
+func (dockerBackend) parse(m *machine, output string) []healthCheck {
+	if m.ContainerList == nil {
+		return nil
+	}
+	checks := []healthCheck{}
+	for _, ct := range m.ContainerList {
+		c := healthCheck{Name: ct.Name, Output: ct.Status}
+		switch ct.State {
+		case containerUnhealthy:
+			c.Status = checkCritical
+		case containerRunning:
+			c.Status = checkPassing
+		case containerRestarting:
+			c.Status = checkWarning
+		case containerExited:
+			c.Status = checkWarning
+			if ct.ExitCode == 0 {
+				c.Status = checkPassing
+			}
+		default:
+			c.Status = checkUnknown
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+func (supervisorBackend) name() string {
+	return "supervisor"
+}
+
+func (supervisorBackend) command(m *machine) string {
+	return `supervisorctl status`
+}
+
+func (supervisorBackend) parse(m *machine, output string) []healthCheck {
+	checks := []healthCheck{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		c := healthCheck{Name: fields[0], Output: strings.Join(fields[2:], " ")}
+		switch fields[1] {
+		case "RUNNING":
+			c.Status = checkPassing
+		case "STARTING", "BACKOFF", "STOPPING":
+			c.Status = checkWarning
+		case "FATAL", "EXITED":
+			c.Status = checkCritical
+		case "STOPPED":
+			c.Status = checkUnknown
+		default:
+			continue
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+func (httpBackend) name() string {
+	return "http"
+}
+
+// command requests the health URL from the machine itself, so it can point
+// to a port that is only reachable locally.
+func (httpBackend) command(m *machine) string {
+	return `curl -s -o /dev/null -m 5 -w '%{http_code}' ` + shellQuote(m.HealthURL)
+}
+
+func (httpBackend) parse(m *machine, output string) []healthCheck {
+	if len(m.HealthURL) == 0 {
+		return nil
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(output))
+	c := healthCheck{Name: m.HealthURL, Output: "HTTP " + strings.TrimSpace(output)}
+	if err != nil || code == 0 {
+		c.Status = checkCritical
+		c.Output = "no response"
+	} else if code >= 200 && code < 300 {
+		c.Status = checkPassing
+	} else if code >= 500 {
+		c.Status = checkCritical
+	} else {
+		c.Status = checkWarning
+	}
+	return []healthCheck{c}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+type (
+	// sample is one fetch result of a machine as kept in the history.
+	sample struct {
+		Time    time.Time `json:"t"`
+		Status  int       `json:"status"`
+		Load1   float32   `json:"load1"`
+		CPU     float32   `json:"cpu"`
+		Free    float32   `json:"free"`
+		Storage []int32   `json:"storage"`
+		Inode   []int32   `json:"inode"`
+		Conns   int32     `json:"conns"`
+	}
+
+	historyMetric struct {
+		name   string
+		value  func(s sample) float64
+		format string
+	}
+)
+
+const sparkWidth = 48
+
+var (
+	historyMutex sync.Mutex
+	sparkRunes   = []rune("▁▂▃▄▅▆▇█")
+	unsafeName   = regexp.MustCompile(`[^A-Za-z0-9._@-]`)
+
+	historyMetrics = []historyMetric{
+		{"load1", func(s sample) float64 { return float64(s.Load1) }, "%.2f"},
+		{"cpu", func(s sample) float64 { return float64(s.CPU) }, "%.1f"},
+		{"free", func(s sample) float64 { return float64(s.Free) }, "%.2f"},
+		{"storage", func(s sample) float64 { return float64(maxInt32(s.Storage)) }, "%.0f"},
+		{"inode", func(s sample) float64 { return float64(maxInt32(s.Inode)) }, "%.0f"},
+		{"conns", func(s sample) float64 { return float64(s.Conns) }, "%.0f"},
+	}
+)
+
+func maxInt32(values []int32) int32 {
+	max := int32(0)
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+func historyFile(name string) string {
+	return filepath.Join(expandHome(*historyDir), unsafeName.ReplaceAllString(name, "_")+".jsonl")
+}
+
+// loadHistory reads the history files of all machines. Samples older than
+// the retention are dropped and the file is rewritten without them.
+// recordSample rewrites a file again once it holds twice as many samples as
+// the history, so the files stay bounded while kone runs.
+func loadHistory() error {
+	if len(*historyDir) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(expandHome(*historyDir), 0700); err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-*retention)
+	for _, m := range machines {
+		if err := loadMachineHistory(m, cutoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadMachineHistory(m *machine, cutoff time.Time) error {
+	m.historyLog.mutex.Lock()
+	defer m.historyLog.mutex.Unlock()
+	err := m.historyLog.load(historyFile(m.Name), func(line []byte) {
+		var s sample
+		if err := json.Unmarshal(line, &s); err == nil && !s.Time.Before(cutoff) {
+			m.History = append(m.History, s)
+		}
+	})
+	if err != nil || m.historyLog.lines == len(m.History) {
+		return err
+	}
+	return m.historyLog.rewrite(historyFile(m.Name), sampleRecords(m.History))
+}
+
+func sampleRecords(history []sample) []interface{} {
+	records := make([]interface{}, len(history))
+	for i, s := range history {
+		records[i] = s
+	}
+	return records
+}
+
+func currentSample(m *machine) sample {
+	s := sample{Time: time.Now(), Status: m.Status}
+	s.Load1, _ = m.Load1.Value.(float32)
+	s.CPU, _ = m.CPU.Value.(float32)
+	s.Free, _ = m.Free.Value.(float32)
+	s.Storage, _ = m.Storage.Value.([]int32)
+	s.Inode, _ = m.Inode.Value.([]int32)
+	s.Conns, _ = m.Connections.Value.(int32)
+	return s
+}
+
+// recordSample adds the current values of the machine to its history and
+// appends them to its history file. The file is written without holding
+// historyMutex, which the detail view takes on every redraw.
+func recordSample(m *machine) {
+	if m.kube != nil {
+		return
+	}
+	s := currentSample(m)
+	m.historyLog.mutex.Lock()
+	defer m.historyLog.mutex.Unlock()
+	historyMutex.Lock()
+	cutoff := s.Time.Add(-*retention)
+	i := 0
+	for i < len(m.History) && m.History[i].Time.Before(cutoff) {
+		i++
+	}
+	m.History = append(m.History[i:], s)
+	keep := len(m.History)
+	historyMutex.Unlock()
+	if len(*historyDir) == 0 {
+		return
+	}
+	err := m.historyLog.append(historyFile(m.Name), s, keep, func() []interface{} {
+		return sampleRecords(getHistory(m))
+	})
+	if err != nil {
+		setStatusMessage("history: %s", err.Error())
+	}
+}
+
+func getHistory(m *machine) []sample {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	return append([]sample{}, m.History...)
+}
+
+// sparkline draws the values scaled between zero (or the minimum when it is
+// negative) and the maximum.
+func sparkline(values []float64) string {
+	min, max := 0.0, 0.0
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	runes := make([]rune, len(values))
+	for i, v := range values {
+		idx := 0
+		if max > min {
+			idx = int((v - min) / (max - min) * float64(len(sparkRunes)-1))
+		}
+		runes[i] = sparkRunes[idx]
+	}
+	return string(runes)
+}
+
+func historyLines(m *machine) []styledText {
+	var lines []styledText
+	history := getHistory(m)
+	if len(history) < 2 {
+		return lines
+	}
+	if len(history) > sparkWidth {
+		history = history[len(history)-sparkWidth:]
+	}
+	lines = append(lines, sectionLine(fmt.Sprintf("History (since %s)", history[0].Time.Format("2006-01-02 15:04"))))
+	for _, metric := range historyMetrics {
+		values := make([]float64, len(history))
+		min, max := metric.value(history[0]), metric.value(history[0])
+		for i, s := range history {
+			values[i] = metric.value(s)
+			if values[i] < min {
+				min = values[i]
+			}
+			if values[i] > max {
+				max = values[i]
+			}
+		}
+		l := textLine(fmt.Sprintf("    %-8s ", metric.name), termbox.ColorDefault)
+		appendStyled(&l, textLine(sparkline(values), 3))
+		format := "  " + metric.format + "  (" + metric.format + " - " + metric.format + ")"
+		appendStyled(&l, textLine(fmt.Sprintf(format, values[len(values)-1], min, max), 9))
+		if t, ok := getTrend(m, metric.name); ok {
+			fg := termbox.Attribute(9)
+			if t.Status != statusOK {
+				fg = statusColor(t.Status)
+			}
+			appendStyled(&l, textLine("  "+formatTrend(t, metric.format), fg))
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	ansibleGroup struct {
+		Hosts    map[string]map[string]interface{} `yaml:"hosts"`
+		Vars     map[string]interface{}            `yaml:"vars"`
+		Children map[string]ansibleGroup           `yaml:"children"`
+	}
+
+	// dataFileGroups is the object form of the data file, it adds settings
+	// shared by all machines of a group to the machine list.
+	dataFileGroups struct {
+		Machines []*machine               `json:"machines"`
+		Groups   map[string]groupSettings `json:"groups"`
+	}
+
+	groupSettings struct {
+		Logs []string `json:"logs"`
+	}
+
+	consulNode struct {
+		Node    string            `json:"Node"`
+		Address string            `json:"Address"`
+		Meta    map[string]string `json:"Meta"`
+	}
+)
+
+const (
+	ansibleHost = "ansible_host"
+	ansibleUser = "ansible_user"
+	ansiblePort = "ansible_port"
+	koneHealth  = "kone_health"
+	koneURL     = "kone_health_url"
+	koneJump    = "kone_jump"
+	koneLogs    = "kone_logs"
+	koneCollect = "kone_collector"
+	koneMaint   = "kone_maintenance"
+	ansibleMeta = "_meta"
+	ansibleAll  = "all"
+)
+
+// getMachines reads the machine list from whichever inventory source has
+// been given on the command line. Kubernetes nodes are added to the machines
+// of the other sources.
+func getMachines() ([]*machine, error) {
+	var ms []*machine
+	var err error
+	switch {
+	case len(*dataFile) > 0:
+		ms, err = getMachinesFromDataFile(*dataFile)
+	case len(*inventory) > 0:
+		ms, err = getMachinesFromInventory(*inventory)
+	case len(*consulCatalog) > 0:
+		ms, err = getMachinesFromConsul(*consulCatalog)
+	case len(*kubeconfig) == 0:
+		return nil, fmt.Errorf("no inventory given, use -data, -inventory, -consul or -kubeconfig")
+	}
+	if err != nil || len(*kubeconfig) == 0 {
+		return ms, err
+	}
+	nodes, err := getMachinesFromKubernetes(*kubeconfig, *kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, m := range ms {
+		names[m.Name] = true
+	}
+	for _, n := range nodes {
+		if names[n.Name] {
+			// the node is also in the inventory as an ssh machine
+			n.Name += "@" + n.kube.cluster.context
+		}
+		ms = append(ms, n)
+	}
+	return ms, nil
+}
+
+func getMachinesFromDataFile(path string) ([]*machine, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var ms []*machine
+		err = json.Unmarshal(data, &ms)
+		if err != nil {
+			return nil, err
+		}
+		return ms, nil
+	}
+	var f dataFileGroups
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	for _, m := range f.Machines {
+		for _, g := range m.Groups {
+			for _, l := range f.Groups[g].Logs {
+				if !contains(m.Logs, l) {
+					m.Logs = append(m.Logs, l)
+				}
+			}
+		}
+	}
+	return f.Machines, nil
+}
+
+// getMachinesFromInventory handles Ansible style inventories. Executable
+// files are treated as dynamic inventory scripts, others are parsed as YAML
+// or INI depending on the file extension.
+func getMachinesFromInventory(path string) ([]*machine, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&0111 != 0 {
+		return getMachinesFromScript(path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return parseAnsibleYAML(data)
+	}
+	return parseAnsibleINI(data)
+}
+
+func getMachinesFromScript(path string) ([]*machine, error) {
+	out, err := exec.Command(path, "--list").Output()
+	if err != nil {
+		return nil, fmt.Errorf("inventory script %s: %s", path, err.Error())
+	}
+	return parseAnsibleJSON(out)
+}
+
+// parseAnsibleJSON parses the output of an Ansible dynamic inventory script.
+// Groups are either lists of hosts or objects with hosts, vars and children.
+func parseAnsibleJSON(data []byte) ([]*machine, error) {
+	var raw map[string]json.RawMessage
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+	var meta struct {
+		HostVars map[string]map[string]interface{} `json:"hostvars"`
+	}
+	if m, ok := raw[ansibleMeta]; ok {
+		if err := json.Unmarshal(m, &meta); err != nil {
+			return nil, err
+		}
+	}
+	groupHosts := make(map[string][]string)
+	groupVars := make(map[string]map[string]interface{})
+	groupChildren := make(map[string][]string)
+	for name, value := range raw {
+		if name == ansibleMeta {
+			continue
+		}
+		var hosts []string
+		if err := json.Unmarshal(value, &hosts); err == nil {
+			groupHosts[name] = hosts
+			continue
+		}
+		var group struct {
+			Hosts    []string               `json:"hosts"`
+			Vars     map[string]interface{} `json:"vars"`
+			Children []string               `json:"children"`
+		}
+		if err := json.Unmarshal(value, &group); err != nil {
+			return nil, fmt.Errorf("inventory group %s: %s", name, err.Error())
+		}
+		groupHosts[name] = group.Hosts
+		groupVars[name] = group.Vars
+		groupChildren[name] = group.Children
+	}
+
+	inv := newAnsibleInventory()
+	for group, hosts := range groupHosts {
+		for _, host := range hosts {
+			inv.addHost(group, host, meta.HostVars[host])
+		}
+	}
+	for group, vars := range groupVars {
+		inv.addGroupVars(group, vars)
+	}
+	for group, children := range groupChildren {
+		for _, child := range children {
+			inv.addChild(group, child)
+		}
+	}
+	for host, vars := range meta.HostVars {
+		inv.addHost("", host, vars)
+	}
+	return inv.machines(), nil
+}
+
+func parseAnsibleYAML(data []byte) ([]*machine, error) {
+	var groups map[string]ansibleGroup
+	err := yaml.Unmarshal(data, &groups)
+	if err != nil {
+		return nil, err
+	}
+	inv := newAnsibleInventory()
+	for name, group := range groups {
+		inv.addYAMLGroup(name, group)
+	}
+	return inv.machines(), nil
+}
+
+// parseAnsibleINI parses the static INI inventory format. Host ranges
+// (e.g. web[01:10]) are not expanded.
+func parseAnsibleINI(data []byte) ([]*machine, error) {
+	inv := newAnsibleInventory()
+	section := ""
+	kind := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.Trim(line, "[]")
+			kind = ""
+			if idx := strings.Index(section, ":"); idx > -1 {
+				section, kind = section[:idx], section[idx+1:]
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		switch kind {
+		case "vars":
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) == 2 {
+				inv.addGroupVars(section, map[string]interface{}{strings.TrimSpace(kv[0]): strings.TrimSpace(kv[1])})
+			}
+		case "children":
+			inv.addChild(section, fields[0])
+		default:
+			vars := make(map[string]interface{})
+			for _, field := range fields[1:] {
+				kv := strings.SplitN(field, "=", 2)
+				if len(kv) == 2 {
+					vars[kv[0]] = kv[1]
+				}
+			}
+			inv.addHost(section, fields[0], vars)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return inv.machines(), nil
+}
+
+// getMachinesFromConsul lists the nodes known to a Consul catalog. The ssh
+// user and port can be set per node through the ssh_user and ssh_port node
+// meta keys, otherwise -user and -port are used.
+func getMachinesFromConsul(address string) ([]*machine, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	client := http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Get(strings.TrimRight(address, "/") + "/v1/catalog/nodes")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul catalog: %s", resp.Status)
+	}
+	var nodes []consulNode
+	err = json.NewDecoder(resp.Body).Decode(&nodes)
+	if err != nil {
+		return nil, err
+	}
+	var ms []*machine
+	for _, node := range nodes {
+		m := &machine{Name: node.Node, Host: node.Address, User: *defaultUser, Port: *defaultPort}
+		if u, ok := node.Meta["ssh_user"]; ok {
+			m.User = u
+		}
+		if p, ok := node.Meta["ssh_port"]; ok {
+			m.Port = p
+		}
+		m.Health = node.Meta[koneHealth]
+		m.HealthURL = node.Meta[koneURL]
+		m.Jump = node.Meta[koneJump]
+		m.Collector = node.Meta[koneCollect]
+		if maintenance, ok := node.Meta[koneMaint]; ok {
+			m.Maintenance = toStringList(maintenance)
+		}
+		if logs, ok := node.Meta[koneLogs]; ok {
+			m.Logs = toStringList(logs)
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+type ansibleInventory struct {
+	hostVars  map[string]map[string]interface{}
+	hostGroup map[string][]string
+	groupVars map[string]map[string]interface{}
+	children  map[string][]string
+}
+
+func newAnsibleInventory() *ansibleInventory {
+	return &ansibleInventory{
+		hostVars:  make(map[string]map[string]interface{}),
+		hostGroup: make(map[string][]string),
+		groupVars: make(map[string]map[string]interface{}),
+		children:  make(map[string][]string),
+	}
+}
+
+func (inv *ansibleInventory) addHost(group, host string, vars map[string]interface{}) {
+	if _, ok := inv.hostVars[host]; !ok {
+		inv.hostVars[host] = make(map[string]interface{})
+	}
+	for k, v := range vars {
+		inv.hostVars[host][k] = v
+	}
+	if len(group) > 0 && group != ansibleAll && !contains(inv.hostGroup[host], group) {
+		inv.hostGroup[host] = append(inv.hostGroup[host], group)
+	}
+}
+
+func (inv *ansibleInventory) addGroupVars(group string, vars map[string]interface{}) {
+	if _, ok := inv.groupVars[group]; !ok {
+		inv.groupVars[group] = make(map[string]interface{})
+	}
+	for k, v := range vars {
+		inv.groupVars[group][k] = v
+	}
+}
+
+func (inv *ansibleInventory) addChild(group, child string) {
+	if !contains(inv.children[group], child) {
+		inv.children[group] = append(inv.children[group], child)
+	}
+}
+
+func (inv *ansibleInventory) addYAMLGroup(name string, group ansibleGroup) {
+	for host, vars := range group.Hosts {
+		inv.addHost(name, host, vars)
+	}
+	inv.addGroupVars(name, group.Vars)
+	for child, g := range group.Children {
+		inv.addChild(name, child)
+		inv.addYAMLGroup(child, g)
+	}
+}
+
+// groupsOf returns the groups a host belongs to, including the parents of
+// its direct groups.
+func (inv *ansibleInventory) groupsOf(host string) []string {
+	groups := append([]string{}, inv.hostGroup[host]...)
+	for i := 0; i < len(groups); i++ {
+		for parent, children := range inv.children {
+			if parent != ansibleAll && contains(children, groups[i]) && !contains(groups, parent) {
+				groups = append(groups, parent)
+			}
+		}
+	}
+	return groups
+}
+
+func (inv *ansibleInventory) machines() []*machine {
+	var hosts []string
+	for host := range inv.hostVars {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	var ms []*machine
+	for _, host := range hosts {
+		groups := inv.groupsOf(host)
+		vars := make(map[string]interface{})
+		for k, v := range inv.groupVars[ansibleAll] {
+			vars[k] = v
+		}
+		// parents come last in groups, so apply them first
+		for i := len(groups) - 1; i >= 0; i-- {
+			for k, v := range inv.groupVars[groups[i]] {
+				vars[k] = v
+			}
+		}
+		for k, v := range inv.hostVars[host] {
+			vars[k] = v
+		}
+		m := &machine{Name: host, Host: host, User: *defaultUser, Port: *defaultPort, Groups: groups}
+		if v, ok := vars[ansibleHost]; ok {
+			m.Host = fmt.Sprintf("%v", v)
+		}
+		if v, ok := vars[ansibleUser]; ok {
+			m.User = fmt.Sprintf("%v", v)
+		}
+		if v, ok := vars[ansiblePort]; ok {
+			m.Port = fmt.Sprintf("%v", v)
+		}
+		if v, ok := vars[koneHealth]; ok {
+			m.Health = fmt.Sprintf("%v", v)
+		}
+		if v, ok := vars[koneURL]; ok {
+			m.HealthURL = fmt.Sprintf("%v", v)
+		}
+		if v, ok := vars[koneJump]; ok {
+			m.Jump = fmt.Sprintf("%v", v)
+		}
+		if v, ok := vars[koneLogs]; ok {
+			m.Logs = toStringList(v)
+		}
+		if v, ok := vars[koneCollect]; ok {
+			m.Collector = fmt.Sprintf("%v", v)
+		}
+		if v, ok := vars[koneMaint]; ok {
+			m.Maintenance = toStringList(v)
+		}
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+// toStringList converts a list or a comma separated string variable to a
+// list of strings.
+func toStringList(v interface{}) []string {
+	var list []string
+	switch t := v.(type) {
+	case []interface{}:
+		for _, i := range t {
+			list = append(list, fmt.Sprintf("%v", i))
+		}
+	default:
+		for _, s := range strings.Split(fmt.Sprintf("%v", t), ",") {
+			if s = strings.TrimSpace(s); len(s) > 0 {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
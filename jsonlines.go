@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// jsonLines is a file with one JSON record per line that records are
+// appended to. It is rewritten with only the kept records once it holds twice
+// as many lines, so it stays bounded while kone runs. The history files and
+// the -events file use it, callers hold mutex while they load or write it.
+type jsonLines struct {
+	lines int
+	mutex sync.Mutex
+}
+
+// load calls each for every line of the file, a missing file is empty.
+func (j *jsonLines) load(path string, each func(line []byte)) error {
+	j.lines = 0
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		j.lines++
+		each(scanner.Bytes())
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// rewrite replaces the file with the records, through a temporary file so
+// that a failed write keeps the old one.
+func (j *jsonLines) rewrite(path string, records []interface{}) error {
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	j.lines = len(records)
+	return nil
+}
+
+// append adds the record to the file. keep is the number of records that
+// are kept in memory, including the new one, and kept returns them when the
+// file has to be rewritten.
+func (j *jsonLines) append(path string, record interface{}, keep int, kept func() []interface{}) error {
+	if j.lines+1 > 2*keep {
+		return j.rewrite(path, kept())
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(record); err != nil {
+		return err
+	}
+	j.lines++
+	return nil
+}
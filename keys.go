@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
+)
+
+type (
+	namedCommand struct {
+		Description string `json:"description"`
+		Command     string `json:"command"`
+	}
+
+	// keymapFile is the format of the -keys file. Keys and group keys map a
+	// key to an action with an optional argument, e.g. "command disk".
+	keymapFile struct {
+		Commands map[string]namedCommand      `json:"commands"`
+		Keys     map[string]string            `json:"keys"`
+		Groups   map[string]map[string]string `json:"groups"`
+	}
+
+	keyBinding struct {
+		key termbox.Key
+		ch  rune
+		alt bool
+	}
+
+	boundAction struct {
+		spec   string
+		action string
+	}
+
+	action struct {
+		description string
+		run         func(arg string)
+	}
+)
+
+var (
+	commands     = make(map[string]namedCommand)
+	keymap       = make(map[keyBinding]boundAction)
+	groupKeymaps = make(map[string]map[keyBinding]boundAction)
+	actions      map[string]action
+
+	showHelp bool
+	quit     bool
+
+	defaultKeys = map[string]string{
+		"f":      "reconnect",
+		"s":      "silent",
+		"i":      "ips",
+		"d":      "details",
+		"?":      "help",
+		":":      "palette",
+		"space":  "mark",
+		"*":      "mark-all",
+		">":      "push",
+		"<":      "pull",
+		"l":      "logs",
+		"p":      "processes",
+		"m":      "maintenance",
+		"a":      "acknowledge",
+		"e":      "events",
+		"ctrl+r": "refresh",
+		"ctrl+a": "refresh-all",
+		"ctrl+f": "search",
+		"enter":  "console",
+	}
+
+	functionKeys = []termbox.Key{
+		termbox.KeyF1, termbox.KeyF2, termbox.KeyF3, termbox.KeyF4,
+		termbox.KeyF5, termbox.KeyF6, termbox.KeyF7, termbox.KeyF8,
+		termbox.KeyF9, termbox.KeyF10, termbox.KeyF11, termbox.KeyF12,
+	}
+
+	namedKeys = map[string]termbox.Key{
+		"enter":  termbox.KeyEnter,
+		"tab":    termbox.KeyTab,
+		"space":  termbox.KeySpace,
+		"insert": termbox.KeyInsert,
+		"delete": termbox.KeyDelete,
+		"left":   termbox.KeyArrowLeft,
+		"right":  termbox.KeyArrowRight,
+	}
+)
+
+func init() {
+	actions = map[string]action{
+		"refresh":     {"reload status info of the selected machine", func(string) { handleCtrlR() }},
+		"refresh-all": {"reload status info of all machines", func(string) { handleCtrlA() }},
+		"reconnect":   {"toggle forced re-connect", func(string) { forceReConnect = !forceReConnect }},
+		"silent":      {"toggle showing only warnings and errors", func(string) { toggleSilent() }},
+		"ips":         {"toggle showing machine IPs", func(string) { toggleIPs() }},
+		"details":     {"open detail view of the selected machine", func(string) { openDetailView() }},
+		"search":      {"search machines by name / IP", func(string) { search = true }},
+		"filter":      {"show machines matching", setFilter},
+		"console":     {"open shell to the selected machine", openConsole},
+		"command":     {"open shell and run", runNamedCommand},
+		"mark":        {"mark / unmark the selected machine", func(string) { toggleMark() }},
+		"mark-all":    {"mark / unmark all machines of the search result", func(string) { toggleMarkAll() }},
+		"palette":     {"run a command on the selected / filtered / marked machines", openPalette},
+		"push":        {"copy a local file to the selected / filtered / marked machines", openPush},
+		"pull":        {"copy a remote file from the selected / filtered / marked machines", openPull},
+		"logs":        {"tail the logs of the selected / marked machines", openLogs},
+		"processes":   {"show top processes of the selected machine", openProcesses},
+		"maintenance": {"put the selected / filtered / marked machines or a group into maintenance", openMaintenance},
+		"acknowledge": {"acknowledge the warnings / errors of the selected / filtered / marked machines", openAcknowledge},
+		"events":      {"show the event log", openEvents},
+		"help":        {"show key bindings", func(string) { showHelp = !showHelp }},
+		"quit":        {"exit", func(string) { quit = true }},
+	}
+}
+
+// loadKeymap sets up the default bindings, the F-keys of the -cmd file and
+// finally the bindings of the -keys file.
+func loadKeymap() error {
+	for spec, a := range defaultKeys {
+		if err := bindKey(keymap, spec, a); err != nil {
+			return err
+		}
+	}
+	if *cmdFile != "" {
+		if err := getCommandsFromFile(); err != nil {
+			return err
+		}
+	}
+	if *keysFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(expandHome(*keysFile))
+	if err != nil {
+		return err
+	}
+	var km keymapFile
+	err = json.Unmarshal(data, &km)
+	if err != nil {
+		return fmt.Errorf("%s: %s", *keysFile, err.Error())
+	}
+	for name, c := range km.Commands {
+		commands[name] = c
+	}
+	for spec, a := range km.Keys {
+		if err := bindKey(keymap, spec, a); err != nil {
+			return err
+		}
+	}
+	for group, keys := range km.Groups {
+		if _, ok := groupKeymaps[group]; !ok {
+			groupKeymaps[group] = make(map[keyBinding]boundAction)
+		}
+		for spec, a := range keys {
+			if err := bindKey(groupKeymaps[group], spec, a); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getCommandsFromFile reads the F1=cmd lines of the -cmd file, every command
+// is bound to its F-key.
+func getCommandsFromFile() error {
+	data, err := ioutil.ReadFile(expandHome(*cmdFile))
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		strs := strings.SplitN(strings.TrimRight(line, "\r"), "=", 2)
+		if len(strs) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(strs[0])
+		if _, err := parseKey(key); err != nil || !strings.HasPrefix(key, "F") {
+			continue
+		}
+		commands[key] = namedCommand{Command: strs[1]}
+		bindKey(keymap, key, "command "+key)
+	}
+	return nil
+}
+
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~") {
+		u, err := user.Current()
+		if err == nil {
+			return strings.Replace(path, "~", u.HomeDir, 1)
+		}
+	}
+	return path
+}
+
+func bindKey(km map[keyBinding]boundAction, spec, a string) error {
+	b, err := parseKey(spec)
+	if err != nil {
+		return err
+	}
+	name := strings.Fields(a)
+	if len(name) == 0 {
+		delete(km, b)
+		return nil
+	}
+	if _, ok := actions[name[0]]; !ok {
+		return fmt.Errorf("unknown action '%s' for key '%s'", name[0], spec)
+	}
+	km[b] = boundAction{spec: spec, action: a}
+	return nil
+}
+
+// parseKey parses key specs like "x", "F5", "ctrl+r", "alt+x" or "enter".
+func parseKey(spec string) (keyBinding, error) {
+	b := keyBinding{}
+	parts := strings.Split(spec, "+")
+	key := parts[len(parts)-1]
+	if len(key) == 0 && len(parts) > 1 {
+		// "alt++"
+		key = "+"
+		parts = parts[:len(parts)-1]
+	}
+	ctrl := false
+	for _, mod := range parts[:len(parts)-1] {
+		switch strings.ToLower(mod) {
+		case "ctrl":
+			ctrl = true
+		case "alt":
+			b.alt = true
+		default:
+			return b, fmt.Errorf("unknown modifier '%s' in key '%s'", mod, spec)
+		}
+	}
+	lower := strings.ToLower(key)
+	if k, ok := namedKeys[lower]; ok && !ctrl {
+		b.key = k
+		return b, nil
+	}
+	if len(lower) > 1 && lower[0] == 'f' {
+		n, err := strconv.Atoi(lower[1:])
+		if err == nil && n > 0 && n <= len(functionKeys) && !ctrl {
+			b.key = functionKeys[n-1]
+			return b, nil
+		}
+	}
+	if utf8.RuneCountInString(key) != 1 {
+		return b, fmt.Errorf("unknown key '%s'", spec)
+	}
+	r, _ := utf8.DecodeRuneInString(key)
+	if ctrl {
+		r = []rune(lower)[0]
+		if r < 'a' || r > 'z' {
+			return b, fmt.Errorf("unknown key '%s'", spec)
+		}
+		b.key = termbox.KeyCtrlA + termbox.Key(r-'a')
+		return b, nil
+	}
+	b.ch = r
+	return b, nil
+}
+
+func eventToBinding(ev termbox.Event) keyBinding {
+	b := keyBinding{alt: ev.Mod&termbox.ModAlt != 0}
+	if ev.Ch != 0 {
+		b.ch = ev.Ch
+	} else {
+		b.key = ev.Key
+	}
+	return b
+}
+
+// getKeymap merges the global bindings with the bindings of the machine's
+// groups, earlier groups take precedence.
+func getKeymap(m *machine) map[keyBinding]boundAction {
+	km := make(map[keyBinding]boundAction)
+	for b, a := range keymap {
+		km[b] = a
+	}
+	for i := len(m.Groups) - 1; i >= 0; i-- {
+		for b, a := range groupKeymaps[m.Groups[i]] {
+			km[b] = a
+		}
+	}
+	return km
+}
+
+func hasAltBindings() bool {
+	for b := range keymap {
+		if b.alt {
+			return true
+		}
+	}
+	for _, km := range groupKeymaps {
+		for b := range km {
+			if b.alt {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleBoundKey runs the action bound to the key event, it returns false if
+// nothing is bound to it.
+func handleBoundKey(ev termbox.Event) bool {
+	a, ok := getKeymap(getSelectedMachine())[eventToBinding(ev)]
+	if !ok {
+		return false
+	}
+	runAction(a.action)
+	return true
+}
+
+func runAction(a string) {
+	fields := strings.SplitN(strings.TrimSpace(a), " ", 2)
+	arg := ""
+	if len(fields) > 1 {
+		arg = strings.TrimSpace(fields[1])
+	}
+	if act, ok := actions[fields[0]]; ok {
+		act.run(arg)
+	}
+}
+
+func runNamedCommand(name string) {
+	if c, ok := commands[name]; ok {
+		openConsole(c.Command)
+	}
+}
+
+func setFilter(text string) {
+	search = len(text) > 0
+	searchString = text
+	cursorPosition = 0
+	startPosition = 0
+	matchingCount = 0
+	for k := range matchingMachines {
+		matchingMachines[k] = false
+	}
+	formatAll()
+}
+
+func toggleSilent() {
+	silent = !silent
+	resetColumnWidths()
+	formatAll()
+}
+
+func toggleIPs() {
+	showIPs = !showIPs
+	resetColumnWidths()
+	formatAll()
+}
+
+func resetColumnWidths() {
+	for _, h := range tic.Header {
+		putToColumnWidthMap(h, len(h))
+	}
+}
+
+func describeAction(a string) string {
+	fields := strings.SplitN(strings.TrimSpace(a), " ", 2)
+	act := actions[fields[0]]
+	if len(fields) == 1 {
+		return act.description
+	}
+	arg := strings.TrimSpace(fields[1])
+	if fields[0] == "command" {
+		if c, ok := commands[arg]; ok {
+			if len(c.Description) > 0 {
+				return c.Description
+			}
+			return act.description + " " + c.Command
+		}
+	}
+	return act.description + " " + arg
+}
+
+// drawHelp lists the key bindings of the selected machine in a box over the
+// machine list.
+func drawHelp() {
+	w, h := termbox.Size()
+	var bound []boundAction
+	for _, a := range getKeymap(getSelectedMachine()) {
+		bound = append(bound, a)
+	}
+	sort.Slice(bound, func(i, j int) bool {
+		return strings.ToLower(bound[i].spec) < strings.ToLower(bound[j].spec)
+	})
+	lines := []string{}
+	for _, a := range bound {
+		lines = append(lines, fmt.Sprintf("%-10s %s", a.spec, describeAction(a.action)))
+	}
+	lines = append(lines, "", fmt.Sprintf("%-10s %s", "arrows", "move / PgUp, PgDn, Home, End"), fmt.Sprintf("%-10s %s", "Esc", "exit search / program"))
+	width := 0
+	for _, l := range lines {
+		if len(l) > width {
+			width = len(l)
+		}
+	}
+	width += 4
+	if width > w-2 {
+		width = w - 2
+	}
+	left := (w - width) / 2
+	top := (h - len(lines) - 2) / 2
+	if top < 0 {
+		top = 0
+	}
+	for i := -1; i <= len(lines); i++ {
+		for j := 0; j < width; j++ {
+			termbox.SetCell(left+j, top+1+i, ' ', termbox.ColorDefault, selectedBg)
+		}
+		if i < 0 || i == len(lines) {
+			continue
+		}
+		for j, r := range []rune(lines[i]) {
+			if j < width-4 {
+				termbox.SetCell(left+2+j, top+1+i, r, selectedFg, selectedBg)
+			}
+		}
+	}
+}
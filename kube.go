@@ -0,0 +1,450 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// kubeConfig is the part of a kubeconfig file needed to reach the API
+	// server. Exec and auth provider plugins are not supported.
+	kubeConfig struct {
+		CurrentContext string `yaml:"current-context"`
+		Clusters       []struct {
+			Name    string `yaml:"name"`
+			Cluster struct {
+				Server   string `yaml:"server"`
+				CA       string `yaml:"certificate-authority"`
+				CAData   string `yaml:"certificate-authority-data"`
+				Insecure bool   `yaml:"insecure-skip-tls-verify"`
+			} `yaml:"cluster"`
+		} `yaml:"clusters"`
+		Contexts []struct {
+			Name    string `yaml:"name"`
+			Context struct {
+				Cluster string `yaml:"cluster"`
+				User    string `yaml:"user"`
+			} `yaml:"context"`
+		} `yaml:"contexts"`
+		Users []struct {
+			Name string `yaml:"name"`
+			User struct {
+				Token          string `yaml:"token"`
+				TokenFile      string `yaml:"tokenFile"`
+				ClientCert     string `yaml:"client-certificate"`
+				ClientCertData string `yaml:"client-certificate-data"`
+				ClientKey      string `yaml:"client-key"`
+				ClientKeyData  string `yaml:"client-key-data"`
+				Username       string `yaml:"username"`
+				Password       string `yaml:"password"`
+			} `yaml:"user"`
+		} `yaml:"users"`
+	}
+
+	kubeCluster struct {
+		context  string
+		server   string
+		token    string
+		username string
+		password string
+		client   *http.Client
+	}
+
+	kubeCondition struct {
+		Type    string `json:"type"`
+		Status  string `json:"status"`
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}
+
+	kubeNodeObject struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			Unschedulable bool `json:"unschedulable"`
+		} `json:"spec"`
+		Status struct {
+			Allocatable map[string]string `json:"allocatable"`
+			Conditions  []kubeCondition   `json:"conditions"`
+			Addresses   []struct {
+				Type    string `json:"type"`
+				Address string `json:"address"`
+			} `json:"addresses"`
+			NodeInfo struct {
+				KubeletVersion string `json:"kubeletVersion"`
+				OSImage        string `json:"osImage"`
+				KernelVersion  string `json:"kernelVersion"`
+			} `json:"nodeInfo"`
+		} `json:"status"`
+	}
+
+	kubeContainer struct {
+		Resources struct {
+			Requests map[string]string `json:"requests"`
+		} `json:"resources"`
+	}
+
+	kubePod struct {
+		Spec struct {
+			InitContainers []kubeContainer `json:"initContainers"`
+			Containers     []kubeContainer `json:"containers"`
+		} `json:"spec"`
+	}
+
+	kubePodList struct {
+		Items []kubePod `json:"items"`
+	}
+
+	// kubeNode is the state of a Kubernetes node row. Requests are the sums
+	// of the effective requests of the node's pods that are not finished.
+	kubeNode struct {
+		cluster        *kubeCluster
+		name           string
+		conditions     []kubeCondition
+		unschedulable  bool
+		kubeletVersion string
+		osImage        string
+		pods           int
+		podCapacity    float64
+		cpuRequested   float64
+		cpuAllocatable float64
+		memRequested   float64
+		memAllocatable float64
+	}
+)
+
+const kubeGroup = "kubernetes"
+
+// kubePressures are the node conditions that are a problem when true.
+var kubePressures = []string{"MemoryPressure", "DiskPressure", "PIDPressure", "NetworkUnavailable"}
+
+func loadKubeCluster(path, context string) (*kubeCluster, error) {
+	path = expandHome(path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kc kubeConfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	// relative file references are relative to the kubeconfig
+	resolve := func(p string) string {
+		if len(p) == 0 || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(filepath.Dir(path), p)
+	}
+	if len(context) == 0 {
+		context = kc.CurrentContext
+	}
+	c := &kubeCluster{context: context}
+	clusterName, userName := "", ""
+	for _, ctx := range kc.Contexts {
+		if ctx.Name == context {
+			clusterName, userName = ctx.Context.Cluster, ctx.Context.User
+		}
+	}
+	if len(clusterName) == 0 {
+		return nil, fmt.Errorf("%s: context '%s' not found", path, context)
+	}
+	tlsConfig := &tls.Config{}
+	for _, cl := range kc.Clusters {
+		if cl.Name != clusterName {
+			continue
+		}
+		c.server = strings.TrimRight(cl.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = cl.Cluster.Insecure
+		ca, err := readKubeData(cl.Cluster.CAData, resolve(cl.Cluster.CA))
+		if err != nil {
+			return nil, err
+		}
+		if ca != nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("%s: invalid certificate authority of cluster '%s'", path, clusterName)
+			}
+		}
+	}
+	if len(c.server) == 0 {
+		return nil, fmt.Errorf("%s: cluster '%s' not found", path, clusterName)
+	}
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		c.token, c.username, c.password = u.User.Token, u.User.Username, u.User.Password
+		if len(c.token) == 0 && len(u.User.TokenFile) > 0 {
+			token, err := ioutil.ReadFile(resolve(u.User.TokenFile))
+			if err != nil {
+				return nil, err
+			}
+			c.token = strings.TrimSpace(string(token))
+		}
+		cert, err := readKubeData(u.User.ClientCertData, resolve(u.User.ClientCert))
+		if err != nil {
+			return nil, err
+		}
+		key, err := readKubeData(u.User.ClientKeyData, resolve(u.User.ClientKey))
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil && key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: user '%s': %s", path, userName, err.Error())
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+	c.client = &http.Client{
+		Timeout:   15 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	return c, nil
+}
+
+// readKubeData returns the base64 decoded inline data or else the content of
+// the file, nil if neither is given.
+func readKubeData(data, file string) ([]byte, error) {
+	if len(data) > 0 {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if len(file) > 0 {
+		return ioutil.ReadFile(file)
+	}
+	return nil, nil
+}
+
+func (c *kubeCluster) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", c.server+path, nil)
+	if err != nil {
+		return err
+	}
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if len(c.username) > 0 {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kubernetes %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// getMachinesFromKubernetes lists the nodes of the cluster as machines, they
+// can be reached with ssh on their internal address.
+func getMachinesFromKubernetes(path, context string) ([]*machine, error) {
+	cluster, err := loadKubeCluster(path, context)
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Items []kubeNodeObject `json:"items"`
+	}
+	if err := cluster.get("/api/v1/nodes", &list); err != nil {
+		return nil, err
+	}
+	var ms []*machine
+	for _, node := range list.Items {
+		m := &machine{Name: node.Metadata.Name, Host: node.Metadata.Name, User: *defaultUser, Port: *defaultPort, Groups: []string{kubeGroup}}
+		for _, a := range node.Status.Addresses {
+			if a.Type == "InternalIP" {
+				m.Host = a.Address
+				break
+			}
+		}
+		m.kube = &kubeNode{cluster: cluster, name: node.Metadata.Name}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// fetchKubeNode reads the node and its pods from the API server.
+func fetchKubeNode(m *machine) error {
+	n := m.kube
+	var node kubeNodeObject
+	if err := n.cluster.get("/api/v1/nodes/"+url.PathEscape(n.name), &node); err != nil {
+		return err
+	}
+	selector := url.QueryEscape("spec.nodeName=" + n.name + ",status.phase!=Succeeded,status.phase!=Failed")
+	var pods kubePodList
+	if err := n.cluster.get("/api/v1/pods?fieldSelector="+selector, &pods); err != nil {
+		return err
+	}
+	n.conditions = node.Status.Conditions
+	n.unschedulable = node.Spec.Unschedulable
+	n.kubeletVersion = node.Status.NodeInfo.KubeletVersion
+	n.osImage = node.Status.NodeInfo.OSImage
+	n.cpuAllocatable = parseQuantity(node.Status.Allocatable["cpu"])
+	n.memAllocatable = parseQuantity(node.Status.Allocatable["memory"])
+	n.podCapacity = parseQuantity(node.Status.Allocatable["pods"])
+	n.pods = len(pods.Items)
+	n.cpuRequested, n.memRequested = 0, 0
+	for _, p := range pods.Items {
+		n.cpuRequested += p.request("cpu")
+		n.memRequested += p.request("memory")
+	}
+	m.OS = "Linux"
+	m.Kernel = node.Status.NodeInfo.KernelVersion
+	return nil
+}
+
+// request returns the effective request of the pod for the resource like the
+// scheduler sees it: the sum of the container requests, or the largest init
+// container request if that is higher as init containers run one by one.
+func (p kubePod) request(resource string) float64 {
+	sum := 0.0
+	for _, c := range p.Spec.Containers {
+		sum += parseQuantity(c.Resources.Requests[resource])
+	}
+	for _, c := range p.Spec.InitContainers {
+		if r := parseQuantity(c.Resources.Requests[resource]); r > sum {
+			sum = r
+		}
+	}
+	return sum
+}
+
+// parseQuantity parses Kubernetes resource quantities like 250m, 2, 128Mi
+// or 1G, unparsable quantities are 0.
+func parseQuantity(q string) float64 {
+	suffixes := []struct {
+		suffix string
+		mult   float64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40}, {"Pi", 1 << 50}, {"Ei", 1 << 60},
+		{"m", 1e-3}, {"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12}, {"P", 1e15}, {"E", 1e18},
+	}
+	for _, s := range suffixes {
+		if strings.HasSuffix(q, s.suffix) {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(q, s.suffix), 64)
+			if err != nil {
+				return 0
+			}
+			return v * s.mult
+		}
+	}
+	v, err := strconv.ParseFloat(q, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+func percentOf(v, total float64) float32 {
+	if total == 0 {
+		return 0
+	}
+	return float32(v * 100 / total)
+}
+
+func (n *kubeNode) condition(t string) (kubeCondition, bool) {
+	for _, c := range n.conditions {
+		if c.Type == t {
+			return c, true
+		}
+	}
+	return kubeCondition{}, false
+}
+
+// worstCondition returns the name shown in the column: NotReady, the first
+// pressure condition that is true, Unschedulable or Ready.
+func (n *kubeNode) worstCondition() (string, int) {
+	if c, ok := n.condition("Ready"); !ok || c.Status != "True" {
+		return "NotReady", statusError
+	}
+	for _, p := range kubePressures {
+		if c, ok := n.condition(p); ok && c.Status == "True" {
+			if p == "NetworkUnavailable" {
+				return p, statusError
+			}
+			return p, statusWarning
+		}
+	}
+	if n.unschedulable {
+		return "Unschedulable", statusWarning
+	}
+	return "Ready", statusOK
+}
+
+func getKubeCPUStatus(n *kubeNode) int {
+	return getValueStatus(measurement{Value: percentOf(n.cpuRequested, n.cpuAllocatable)}, 85, 95)
+}
+
+func getKubeMemoryStatus(n *kubeNode) int {
+	return getValueStatus(measurement{Value: percentOf(n.memRequested, n.memAllocatable)}, 85, 95)
+}
+
+func getKubePodsStatus(n *kubeNode) int {
+	return getValueStatus(measurement{Value: percentOf(float64(n.pods), n.podCapacity)}, 90, 100)
+}
+
+func getKubeStatus(machine *machine) int {
+	n := machine.kube
+	_, status := n.worstCondition()
+	return status | getKubeCPUStatus(n) | getKubeMemoryStatus(n) | getKubePodsStatus(n)
+}
+
+func kubeLines(m *machine) []styledText {
+	var lines []styledText
+	n := m.kube
+	if n == nil || len(n.conditions) == 0 {
+		return lines
+	}
+	lines = append(lines, sectionLine("Kubernetes node ("+n.cluster.context+")"))
+	lines = append(lines, textLine(fmt.Sprintf("    %-14s %s", "kubelet", n.kubeletVersion), termbox.ColorDefault))
+	lines = append(lines, textLine(fmt.Sprintf("    %-14s %s", "os", n.osImage), termbox.ColorDefault))
+	if n.unschedulable {
+		lines = append(lines, textLine(fmt.Sprintf("    %-14s %s", "scheduling", "disabled (cordoned)"), statusColor(statusWarning)))
+	}
+	resource := func(name string, requested, allocatable string, status int) styledText {
+		fg := termbox.Attribute(termbox.ColorDefault)
+		if status != statusOK {
+			fg = statusColor(status)
+		}
+		return textLine(fmt.Sprintf("    %-14s %s / %s", name, requested, allocatable), fg)
+	}
+	lines = append(lines,
+		resource("pods", strconv.Itoa(n.pods), fmt.Sprintf("%.0f", n.podCapacity), getKubePodsStatus(n)),
+		resource("cpu requests", fmt.Sprintf("%.2f", n.cpuRequested), fmt.Sprintf("%.2f", n.cpuAllocatable), getKubeCPUStatus(n)),
+		resource("mem requests", formatBytes(int64(n.memRequested)), formatBytes(int64(n.memAllocatable)), getKubeMemoryStatus(n)),
+	)
+	lines = append(lines, newStyledText(), sectionLine("Conditions"))
+	conditions := append([]kubeCondition{}, n.conditions...)
+	sort.Slice(conditions, func(i, j int) bool { return conditions[i].Type < conditions[j].Type })
+	for _, c := range conditions {
+		bad := (c.Type == "Ready") != (c.Status == "True")
+		fg := termbox.Attribute(3)
+		if bad {
+			fg = 2 | termbox.AttrBold
+		}
+		text := fmt.Sprintf("    %-20s %-7s %s", c.Type, c.Status, c.Reason)
+		if bad && len(c.Message) > 0 {
+			text += ": " + c.Message
+		}
+		lines = append(lines, textLine(text, fg))
+	}
+	return lines
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testNodes = `{"items": [
+  {"metadata": {"name": "node-1"}, "status": {"addresses": [{"type": "Hostname", "address": "node-1"}, {"type": "InternalIP", "address": "10.0.0.1"}]}},
+  {"metadata": {"name": "node-2"}, "status": {"addresses": [{"type": "Hostname", "address": "node-2"}]}}
+]}`
+
+	testNode = `{
+  "metadata": {"name": "node-1"},
+  "spec": {"unschedulable": true},
+  "status": {
+    "allocatable": {"cpu": "4", "memory": "8Gi", "pods": "110"},
+    "conditions": [{"type": "Ready", "status": "True"}, {"type": "MemoryPressure", "status": "False"}],
+    "nodeInfo": {"kubeletVersion": "v1.30.1", "osImage": "Ubuntu 24.04", "kernelVersion": "6.8.0"}
+  }
+}`
+
+	testPods = `{"items": [
+  {"spec": {"containers": [{"resources": {"requests": {"cpu": "250m", "memory": "256Mi"}}}, {"resources": {"requests": {"cpu": "250m"}}}]}},
+  {"spec": {
+    "initContainers": [{"resources": {"requests": {"cpu": "2", "memory": "64Mi"}}}],
+    "containers": [{"resources": {"requests": {"cpu": "500m", "memory": "1Gi"}}}]
+  }}
+]}`
+)
+
+// newTestKubeAPI serves the node list, node-1 and its pods and writes a
+// kubeconfig pointing at the server.
+func newTestKubeAPI(t *testing.T) (*httptest.Server, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/nodes":
+			fmt.Fprint(w, testNodes)
+		case "/api/v1/nodes/node-1":
+			fmt.Fprint(w, testNode)
+		case "/api/v1/pods":
+			if r.URL.Query().Get("fieldSelector") != "spec.nodeName=node-1,status.phase!=Succeeded,status.phase!=Failed" {
+				t.Errorf("unexpected field selector %q", r.URL.Query().Get("fieldSelector"))
+			}
+			fmt.Fprint(w, testPods)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	dir, err := ioutil.TempDir("", "kone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	config := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+- name: test-cluster
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test-cluster
+    user: test-user
+users:
+- name: test-user
+  user:
+    token: secret
+`, server.URL)
+	path := filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return server, path
+}
+
+func TestGetMachinesFromKubernetes(t *testing.T) {
+	server, path := newTestKubeAPI(t)
+	defer server.Close()
+
+	ms, err := getMachinesFromKubernetes(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("got %d machines, want 2", len(ms))
+	}
+	if ms[0].Name != "node-1" || ms[0].Host != "10.0.0.1" {
+		t.Errorf("got %s at %s, want node-1 at 10.0.0.1", ms[0].Name, ms[0].Host)
+	}
+	if ms[1].Host != "node-2" {
+		t.Errorf("got host %s for a node without internal IP, want node-2", ms[1].Host)
+	}
+	if len(ms[0].Groups) != 1 || ms[0].Groups[0] != kubeGroup || ms[0].kube == nil {
+		t.Errorf("node-1 is not a kubernetes machine")
+	}
+
+	if _, err := getMachinesFromKubernetes(path, "missing"); err == nil {
+		t.Errorf("no error for a missing context")
+	}
+}
+
+func TestFetchKubeNode(t *testing.T) {
+	server, path := newTestKubeAPI(t)
+	defer server.Close()
+
+	ms, err := getMachinesFromKubernetes(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := ms[0]
+	if err := fetchKubeNode(m); err != nil {
+		t.Fatal(err)
+	}
+	n := m.kube
+	if n.pods != 2 || n.podCapacity != 110 {
+		t.Errorf("got %d / %.0f pods, want 2 / 110", n.pods, n.podCapacity)
+	}
+	// 250m + 250m, and the 2 cpu init container of the second pod
+	if n.cpuRequested != 2.5 || n.cpuAllocatable != 4 {
+		t.Errorf("got %.2f / %.2f cpu, want 2.50 / 4.00", n.cpuRequested, n.cpuAllocatable)
+	}
+	if n.memRequested != 256<<20+1<<30 || n.memAllocatable != 8<<30 {
+		t.Errorf("got %.0f / %.0f memory", n.memRequested, n.memAllocatable)
+	}
+	if name, status := n.worstCondition(); name != "Unschedulable" || status != statusWarning {
+		t.Errorf("got condition %s (%d), want Unschedulable", name, status)
+	}
+	if m.Kernel != "6.8.0" || n.kubeletVersion != "v1.30.1" {
+		t.Errorf("got kernel %s, kubelet %s", m.Kernel, n.kubeletVersion)
+	}
+
+	if err := fetchKubeNode(ms[1]); err == nil {
+		t.Errorf("no error for a node the server does not know")
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	for q, want := range map[string]float64{
+		"2":     2,
+		"250m":  0.25,
+		"1.5":   1.5,
+		"128Mi": 128 << 20,
+		"1Gi":   1 << 30,
+		"1G":    1e9,
+		"100k":  1e5,
+		"":      0,
+		"x":     0,
+		"12Qi":  0,
+	} {
+		if got := parseQuantity(q); got != want {
+			t.Errorf("parseQuantity(%q) = %v, want %v", q, got, want)
+		}
+	}
+}
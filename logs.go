@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nsf/termbox-go"
+	"golang.org/x/crypto/ssh"
+)
+
+// logStream collects the lines of tail -F running on one or more machines.
+type logStream struct {
+	lines    []styledText
+	sessions []*ssh.Session
+	stopped  bool
+	mutex    sync.Mutex
+}
+
+const (
+	maxLogLines = 5000
+	// maxLogLine is the longest line read from tail, longer lines end the
+	// tail with an error.
+	maxLogLine = 1024 * 1024
+)
+
+var hostColors = []termbox.Attribute{3, 5, 7, 11, 13, 15}
+
+// openLogs tails the logs of the marked machines, or of the selected machine
+// if none are marked. path overrides the log files declared for the machines.
+func openLogs(path string) {
+	targets := getMarkedMachines()
+	if len(targets) == 0 {
+		targets = []string{getSelectedMachine().Name}
+	}
+	type tailTarget struct {
+		machine *machine
+		paths   []string
+	}
+	var tails []tailTarget
+	for _, name := range targets {
+		paths := machines[name].Logs
+		if len(path) > 0 {
+			paths = []string{path}
+		}
+		if len(paths) > 0 {
+			tails = append(tails, tailTarget{machines[name], paths})
+		}
+	}
+	if len(tails) == 0 {
+		setStatusMessage("no logs declared for %s", strings.Join(targets, ", "))
+		return
+	}
+	stream := &logStream{}
+	title := tails[0].machine.Name
+	if len(tails) > 1 {
+		title = fmt.Sprintf("%d machines", len(tails))
+	}
+	openPane(&pane{
+		title: func() styledText {
+			return textLine("logs: "+title, termbox.ColorDefault|termbox.AttrBold)
+		},
+		lines:      stream.get,
+		hint:       "Esc: back  /: filter  End: follow",
+		follow:     true,
+		searchable: true,
+		onClose:    stream.stop,
+	})
+	for i, t := range tails {
+		prefix := newStyledText()
+		if len(tails) > 1 {
+			prefix = textLine(t.machine.Name+" ", hostColors[i%len(hostColors)])
+		}
+		go stream.tail(t.machine, t.paths, prefix)
+	}
+}
+
+func (s *logStream) get() []styledText {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lines
+}
+
+func (s *logStream) add(prefix styledText, line styledText) {
+	l := newStyledText()
+	appendStyled(&l, prefix)
+	appendStyled(&l, line)
+	s.mutex.Lock()
+	s.lines = append(s.lines, l)
+	if len(s.lines) > maxLogLines {
+		s.lines = s.lines[len(s.lines)-maxLogLines:]
+	}
+	s.mutex.Unlock()
+	sendRedrawRequest()
+}
+
+// stop ends the tails. The sessions run with a PTY, so closing them hangs up
+// the tail processes also where the server ignores the signal request.
+func (s *logStream) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stopped = true
+	for _, session := range s.sessions {
+		session.Signal(ssh.SIGTERM)
+		session.Close()
+	}
+}
+
+func (s *logStream) tail(m *machine, paths []string, prefix styledText) {
+	err := s.run(m, paths, prefix)
+	s.mutex.Lock()
+	stopped := s.stopped
+	s.mutex.Unlock()
+	if stopped {
+		return
+	}
+	if err != nil {
+		s.add(prefix, textLine("-- "+err.Error(), termbox.ColorRed))
+	} else {
+		s.add(prefix, textLine("-- tail ended", 9))
+	}
+}
+
+func (s *logStream) run(m *machine, paths []string, prefix styledText) error {
+	client, err := getMachineClient(m, false)
+	if err != nil {
+		return err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		dropClient(m, client)
+		return err
+	}
+	s.mutex.Lock()
+	if s.stopped {
+		s.mutex.Unlock()
+		session.Close()
+		return nil
+	}
+	s.sessions = append(s.sessions, session)
+	s.mutex.Unlock()
+
+	out, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var quoted []string
+	for _, p := range paths {
+		if strings.HasPrefix(p, "~/") {
+			quoted = append(quoted, `"$HOME"/`+shellQuote(p[2:]))
+		} else {
+			quoted = append(quoted, shellQuote(p))
+		}
+	}
+	if err := session.RequestPty("dumb", 0, 0, ssh.TerminalModes{ssh.ECHO: 0}); err != nil {
+		return err
+	}
+	err = session.Start("tail -n 50 -F " + strings.Join(quoted, " ") + " 2>&1")
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLine)
+	for scanner.Scan() {
+		s.add(prefix, textLine(strings.TrimSuffix(scanner.Text(), "\r"), termbox.ColorDefault))
+	}
+	if err := scanner.Err(); err != nil {
+		session.Close()
+		return err
+	}
+	return session.Wait()
+}
@@ -4,8 +4,8 @@ import (
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -24,29 +24,22 @@ import (
 	"golang.org/x/crypto/ssh/agent"
 )
 
-type (
-	consulCheck struct {
-		Status string `json:"Status"`
-	}
-)
-
 const (
 	hashType         = "1"
 	unixNetwork      = "unix"
 	updateTimeMillis = 60000 * 5
 	loadCmd          = `cat /proc/loadavg | awk '{print $1,$2,$3}'`
-	freeCmd          = `if [ "$(free | grep available)" ]; then free | grep Mem | awk '{print ($2-$7)/$2}'; else free | grep Mem | awk '{print ($3-$6-$7)/$2}'; fi`
-	connsCmd         = `netstat -ant | awk '{print $5}' | uniq -u | wc -l`
+	connsCmd         = `if command -v ss >/dev/null; then ss -tan | awk 'NR>1 {print $1, $4, $5}'; else netstat -ant | awk 'NR>2 {print $6, $4, $5}'; fi | awk '{s[$1]++} $1=="LISTEN" {n=split($2,a,":"); l[a[n]]=1} $1!="LISTEN" {n=split($3,a,":"); p[substr($3,1,length($3)-length(a[n])-1)]++} END {for (k in s) printf "%s=%d ", k, s[k]; printf "LISTEN_PORTS="; for (k in l) printf "%s,", k; printf " PEERS="; for (k in p) printf "%s/%d,", k, p[k]; print ""}'`
 	procCmd          = `nproc`
 	storageCmd       = `df -x tmpfs -x none | grep '/' | grep '%' | awk '{print $6 "=" $5}' | sort -g | awk '{printf "%s ",$0} END {print " "}'`
 	inodeCmd         = `df -i -x tmpfs -x none | grep '/' | grep '%' | awk '{print $6 "=" $5}' | sort -g | awk '{printf "%s ",$0} END {print " "}'`
 	uptimeCmd        = `cat /proc/uptime | awk '{print $1}'`
-	cpuUtilCmd       = `top -b -n2 | grep "Cpu(s)"| tail -n 1 | awk '{print $2 + $4}'`
-	consulServices   = `curl -s http://localhost:8500/v1/health/node/$(hostname)`
-	command          = loadCmd + ` &&  ` + freeCmd + `&& ` + connsCmd + ` && ` + procCmd + ` && ` + storageCmd + ` && ` + inodeCmd + ` && ` + uptimeCmd + ` && ` + cpuUtilCmd + `&&` + consulServices
+	cpuUtilCmd       = `head -n 1 /proc/stat`
+	failedUnitsCmd   = `if command -v systemctl >/dev/null; then systemctl list-units --failed --no-legend 2>/dev/null | awk '{u=$1; if (u=="●" || u=="*") u=$2; printf "%s ",u} END {print " "}'; else echo "-"; fi`
 )
 
 var (
+	command              string
 	wg                   sync.WaitGroup
 	machines             map[string]*machine
 	signers              []ssh.Signer
@@ -58,12 +51,28 @@ var (
 	sshAuthSocket        = os.Getenv("SSH_AUTH_SOCK")
 )
 
+func buildCommand() string {
+	return loadCmd + ` &&  ` + memInfoCmd + `&& ` + connsCmd + ` && ` + procCmd + ` && ` + storageCmd + ` && ` + inodeCmd + ` && ` + uptimeCmd + ` && ` + cpuUtilCmd + ` && ` + failedUnitsCmd + ` && ` + pressureCmd + ` && ` + diskStatsCmd + ` && ` + netDevCmd
+}
+
+// getCommand appends the container listing and the machine's health backend
+// command to the collector command, the health output takes the rest of the
+// lines after the status values.
+func getCommand(m *machine) string {
+	cmd := getCollector(m).command(m) + ` && ` + containersCommand() + ` && ` + healthCommand(m)
+	if _, ok := osCollectors[m.OS]; ok {
+		// the login shell of BSD machines may be csh
+		return "sh -c " + shellQuote(cmd)
+	}
+	return cmd
+}
+
 func runOnHost(machine string, forceReConnect bool) {
 	if machines[machine].Fetching {
 		return
 	}
 	wg.Add(1)
-	go runCommandOnHost(command, machine, forceReConnect)
+	go runCommandOnHost(machine, forceReConnect)
 	wg.Wait()
 }
 
@@ -71,25 +80,30 @@ func runOnHosts(forceReConnect bool) {
 	for k := range machines {
 		if !machines[k].Fetching {
 			wg.Add(1)
-			go runCommandOnHost(command, k, forceReConnect)
+			go runCommandOnHost(k, forceReConnect)
 		}
 	}
 	wg.Wait()
 }
 
-func runCommandOnHost(command string, machine string, forceReConnect bool) {
+func runCommandOnHost(machine string, forceReConnect bool) {
 	machines[machine].Fetching = true
 	sendRedrawRequest()
+	prevStatus, prevError := machines[machine].Status, machines[machine].FetchingError
 	var err error
 	var result string
-	if machines[machine].client == nil || forceReConnect {
-		machines[machine].client, err = gosh.GetClient(*machines[machine].config, 15*time.Second)
-	}
-	if machines[machine].client != nil {
-		result, err = gosh.RunOnClient(command, *machines[machine].client, 15*time.Second)
-		if op, ok := err.(*net.OpError); ok {
-			if !op.Timeout() {
-				machines[machine].client = nil
+	if machines[machine].kube != nil {
+		err = fetchKubeNode(machines[machine])
+	} else {
+		var client *ssh.Client
+		client, err = getMachineClient(machines[machine], forceReConnect)
+		if err == nil {
+			if len(machines[machine].OS) == 0 {
+				detectOS(machines[machine])
+			}
+			result, err = gosh.RunOnClient(getCommand(machines[machine]), *client, 15*time.Second)
+			if isConnectionError(err) {
+				dropClient(machines[machine], client)
 			}
 		}
 	}
@@ -100,9 +114,17 @@ func runCommandOnHost(command string, machine string, forceReConnect bool) {
 		machines[machine].Status |= statusUnknown
 	} else {
 		machines[machine].GotResult = true
-		populate(machines[machine], result)
+		machines[machine].FetchingError = ""
+		if machines[machine].kube == nil {
+			result = populateContainers(machines[machine], result)
+			getCollector(machines[machine]).populate(machines[machine], result)
+		}
 		setMachineStatus(machines[machine])
+		recordSample(machines[machine])
 	}
+	updateMaintenance(machines[machine])
+	updateAck(machines[machine])
+	logFetch(machines[machine], prevStatus, prevError, err)
 	formatMachine(machine)
 	sendSortingRequest()
 	wg.Done()
@@ -118,6 +140,7 @@ func sortingRoutine() {
 		for len(sortRequestChannel) > 0 {
 			<-sortRequestChannel
 		}
+		formatMaintenanceChanges()
 		sort.Sort(sorter)
 		sendRedrawRequest()
 	}
@@ -125,7 +148,11 @@ func sortingRoutine() {
 
 func populate(machines *machine, result string) {
 	s := strings.Split(result, "\n")
-	loads := strings.Split(s[0], " ")
+	loads := strings.Fields(s[0])
+	for len(loads) < 3 {
+		// missing loads are shown as -1
+		loads = append(loads, "")
+	}
 	l1, err := strconv.ParseFloat(loads[0], 32)
 	if err != nil {
 		l1 = -1
@@ -144,17 +171,9 @@ func populate(machines *machine, result string) {
 	}
 	machines.Load15.Value = float32(l15)
 
-	free, err := strconv.ParseFloat(s[1], 32)
-	if err != nil {
-		free = -1
-	}
-	machines.Free.Value = float32(free)
+	populateMemory(machines, s[1])
 
-	conn, err := strconv.ParseInt(s[2], 10, 32)
-	if err != nil {
-		conn = -1
-	}
-	machines.Connections.Value = int32(conn)
+	populateConnections(machines, s[2])
 
 	nproc, err := strconv.ParseInt(s[3], 10, 32)
 	if err != nil {
@@ -162,10 +181,14 @@ func populate(machines *machine, result string) {
 	}
 	machines.Nproc = int32(nproc)
 
-	driveUsages := strings.Split(strings.TrimSpace(s[4]), " ")
+	driveUsages := strings.Fields(s[4])
 	drives := []int32{}
 	for _, usage := range driveUsages {
-		stor, err := strconv.ParseInt(strings.TrimRight(strings.Split(usage, "=")[1], "%"), 10, 32)
+		kv := strings.SplitN(usage, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		stor, err := strconv.ParseInt(strings.TrimRight(kv[1], "%"), 10, 32)
 		if err != nil {
 			stor = -1
 		}
@@ -173,10 +196,14 @@ func populate(machines *machine, result string) {
 	}
 	machines.Storage.Value = drives
 
-	inodeUsages := strings.Split(strings.TrimSpace(s[5]), " ")
+	inodeUsages := strings.Fields(s[5])
 	inodes := []int32{}
 	for _, usage := range inodeUsages {
-		stor, err := strconv.ParseInt(strings.TrimRight(strings.Split(usage, "=")[1], "%"), 10, 32)
+		kv := strings.SplitN(usage, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		stor, err := strconv.ParseInt(strings.TrimRight(kv[1], "%"), 10, 32)
 		if err != nil {
 			stor = -1
 		}
@@ -190,51 +217,56 @@ func populate(machines *machine, result string) {
 	}
 	machines.Uptime.Value = int64(ut)
 
-	cpu, err := strconv.ParseFloat(s[7], 10)
-	if err != nil {
-		cpu = -1
-	}
-	machines.CPU.Value = float32(cpu)
+	populateCPU(machines, s[7])
 
-	consulChecks := strings.TrimSpace(s[8])
-	if len(consulChecks) > 0 {
-		var checks []consulCheck
-		err := json.Unmarshal([]byte(consulChecks), &checks)
-		if err == nil {
-			checkArray := [4]int32{}
-			for _, check := range checks {
-				switch check.Status {
-				case "passing":
-					checkArray[0]++
-				case "unknown":
-					checkArray[1]++
-				case "warning":
-					checkArray[2]++
-				case "critical":
-					fallthrough
-				default:
-					checkArray[3]++
-				}
-			}
-			machines.Services.Value = checkArray
-		}
+	units := strings.TrimSpace(s[8])
+	if units == "-" {
+		machines.FailedUnits.Value = nil
+	} else if len(units) == 0 {
+		machines.FailedUnits.Value = []string{}
+	} else {
+		machines.FailedUnits.Value = strings.Split(units, " ")
 	}
+
+	populatePressure(machines, s[9])
+
+	now := time.Now()
+	populateDiskRates(machines, s[10], now)
+	populateNetRates(machines, s[11], now)
+
+	populateChecks(machines, strings.Join(s[12:], "\n"))
 }
 
 func setMachineStatus(machine *machine) {
 
 	machine.Status = statusOK
+	updateMaintenance(machine)
 
-	machine.Status |= getLoadStatus(machine, machine.Load1)
+	if machine.kube != nil {
+		machine.Status |= getKubeStatus(machine)
+		return
+	}
+
+	machine.Status |= sustained(machine, "load1", getLoadStatus(machine, machine.Load1))
 	machine.Status |= getLoadStatus(machine, machine.Load5)
 	machine.Status |= getLoadStatus(machine, machine.Load15)
-	machine.Status |= getCPUStatus(machine)
-	machine.Status |= getFreeStatus(machine)
-	machine.Status |= getStorageStatus(machine)
-	machine.Status |= getInodeStatus(machine)
-	machine.Status |= getConnectionsStatus(machine)
+	machine.Status |= sustained(machine, "cpu", getCPUStatus(machine))
+	machine.Status |= getIOWaitStatus(machine)
+	machine.Status |= getStealStatus(machine)
+	machine.Status |= sustained(machine, "free", getFreeStatus(machine))
+	machine.Status |= getSwapStatus(machine)
+	machine.Status |= getPressuresStatus(machine)
+	machine.Status |= sustained(machine, "storage", getStorageStatus(machine))
+	machine.Status |= sustained(machine, "inode", getInodeStatus(machine))
+	machine.Status |= getDiskStatus(machine)
+	machine.Status |= getNetStatus(machine)
+	machine.Status |= sustained(machine, "conns", getConnectionsStatus(machine))
 	machine.Status |= getUptimeStatus(machine)
 	machine.Status |= getServicesStatus(machine)
+	machine.Status |= getFailedUnitsStatus(machine)
+	machine.Status |= getContainersStatus(machine)
+	updateTrends(machine)
+	machine.Status |= getTrendsStatus(machine)
 
 }
 
@@ -325,12 +357,20 @@ func getConnectionsStatus(machine *machine) int {
 	if !ok {
 		err = 58982
 	}
+	status := getConnStatesStatus(machine)
 	if conns < int32(warn) {
-		return statusOK
+		status |= statusOK
 	} else if conns < int32(err) {
+		status |= statusWarning
+	} else {
+		status |= statusError
+	}
+	if status&statusError > 0 {
+		return statusError
+	} else if status&statusWarning > 0 {
 		return statusWarning
 	}
-	return statusError
+	return statusOK
 }
 
 func getLoadStatus(machine *machine, load measurement) int {
@@ -384,6 +424,43 @@ func getServicesStatus(machine *machine) int {
 	return statusOK
 }
 
+// getFailedUnits returns the failed units that are not ignored, nil if the
+// machine does not run systemd.
+func getFailedUnits(machine *machine) []string {
+	units, ok := machine.FailedUnits.Value.([]string)
+	if !ok {
+		return nil
+	}
+	failed := []string{}
+	for _, u := range units {
+		if !isIgnored(u, machine.FailedUnits.Ignore) {
+			failed = append(failed, u)
+		}
+	}
+	return failed
+}
+
+func getFailedUnitsStatus(machine *machine) int {
+	units := getFailedUnits(machine)
+	if units == nil {
+		return statusOK
+	}
+	warn, ok := machine.FailedUnits.Warning.(float64)
+	if !ok {
+		warn = 1
+	}
+	err, ok := machine.FailedUnits.Error.(float64)
+	if !ok {
+		err = 1
+	}
+	if len(units) < int(warn) {
+		return statusOK
+	} else if len(units) < int(err) {
+		return statusWarning
+	}
+	return statusError
+}
+
 func getPassword() ([]byte, error) {
 	machines, err := ioutil.ReadFile(*passFile)
 	if err != nil {
@@ -396,16 +473,14 @@ func getPassword() ([]byte, error) {
 }
 
 func populateMachines() error {
-	data, err := ioutil.ReadFile(*dataFile)
+	ms, err := getMachines()
 	if err != nil {
 		return err
 	}
-	machines = make(map[string]*machine)
-	var ms []*machine
-	err = json.Unmarshal(data, &ms)
-	if err != nil {
-		return err
+	if len(ms) == 0 {
+		return errors.New("inventory contains no machines")
 	}
+	machines = make(map[string]*machine)
 	for _, m := range ms {
 		config := gosh.Config{
 			User:    m.User,
@@ -414,6 +489,16 @@ func populateMachines() error {
 			Timeout: 15 * time.Second,
 			Signers: signers}
 		m.config = &config
+		if err := checkHealthBackend(m); err != nil {
+			return err
+		}
+		if err := checkCollector(m); err != nil {
+			return err
+		}
+		if err := parseMaintenanceWindows(m); err != nil {
+			return err
+		}
+		updateMaintenance(m)
 		machines[m.Name] = m
 	}
 	if len(*knownHosts) > 0 {
@@ -534,7 +619,7 @@ func fetchRandomMachine() {
 	key := sorter.keys[machineNr]
 	if !machines[key].Fetching {
 		wg.Add(1)
-		go runCommandOnHost(command, key, false)
+		go runCommandOnHost(key, false)
 		wg.Wait()
 	}
 }
@@ -555,6 +640,7 @@ func initialFetch() {
 }
 
 func main() {
+	flag.Parse()
 	rand.Seed(time.Now().Unix())
 	var err error
 	signers, err = getSignersFromAgent()
@@ -566,10 +652,23 @@ func main() {
 		signers = append(signers, *signer)
 	}
 
+	command = buildCommand()
+	if err := loadKeymap(); err != nil {
+		fmt.Printf("%s", err.Error())
+		return
+	}
 	if err := populateMachines(); err != nil {
 		fmt.Printf("%s", err.Error())
 		return
 	}
+	if err := loadHistory(); err != nil {
+		fmt.Printf("%s", err.Error())
+		return
+	}
+	if err := loadEvents(); err != nil {
+		fmt.Printf("%s", err.Error())
+		return
+	}
 	initMachines(machines)
 	sorter = machineSorter{keyToIndex: make(map[string]int)}
 	for k := range machines {
@@ -582,5 +681,6 @@ func main() {
 	go sortingRoutine()
 	go initialFetch()
 	go updateRoutine()
+	go maintenanceRoutine()
 	runCli()
 }
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+type (
+	// cronSchedule is a parsed "minute hour day-of-month month day-of-week"
+	// expression, every field is the set of matching values.
+	cronSchedule struct {
+		fields [5]map[int]bool
+		dom    bool
+		dow    bool
+	}
+
+	// maintenanceWindow starts whenever its schedule matches and lasts for
+	// its duration.
+	maintenanceWindow struct {
+		spec     string
+		schedule cronSchedule
+		duration time.Duration
+	}
+)
+
+// cronRanges are the value ranges of the cron fields, 7 is Sunday too.
+var cronRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// maxMaintenanceWindow bounds the search for a window start.
+const maxMaintenanceWindow = 7 * 24 * time.Hour
+
+var (
+	// maintenanceMutex guards the maintenance and InMaintenance fields of
+	// the machines and maintenanceChanged.
+	maintenanceMutex sync.Mutex
+
+	// maintenanceChanged holds the machines whose maintenance was started or
+	// ended by maintenanceRoutine, the sorting routine formats them.
+	maintenanceChanged = make(map[string]bool)
+)
+
+func parseCron(spec string) (cronSchedule, error) {
+	var c cronSchedule
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return c, fmt.Errorf("schedule '%s' needs 5 fields", spec)
+	}
+	for i, f := range fields {
+		values, err := parseCronField(f, cronRanges[i][0], cronRanges[i][1])
+		if err != nil {
+			return c, fmt.Errorf("schedule '%s': %s", spec, err.Error())
+		}
+		c.fields[i] = values
+	}
+	if c.fields[4][7] {
+		c.fields[4][0] = true
+	}
+	c.dom = !strings.HasPrefix(fields[2], "*")
+	c.dow = !strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+// parseCronField handles *, n, a-b and lists of them, all with an
+// optional /step. n/step runs from n to the end of the range.
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step, stepped := 1, false
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("invalid step in '%s'", part)
+			}
+			step, stepped = s, true
+			part = part[:i]
+		}
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			from, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value '%s'", part)
+			}
+			to = from
+			if stepped {
+				to = max
+			}
+			if len(bounds) == 2 {
+				to, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid value '%s'", part)
+				}
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("value '%s' out of range %d-%d", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// matches follows cron in matching either day field when both are
+// restricted.
+func (c cronSchedule) matches(t time.Time) bool {
+	if !c.fields[0][t.Minute()] || !c.fields[1][t.Hour()] || !c.fields[3][int(t.Month())] {
+		return false
+	}
+	dom, dow := c.fields[2][t.Day()], c.fields[4][int(t.Weekday())]
+	if c.dom && c.dow {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// parseMaintenance parses "<cron schedule> <duration>", e.g.
+// "0 2 * * 0 2h" for Sundays from 02:00 to 04:00.
+func parseMaintenance(spec string) (maintenanceWindow, error) {
+	w := maintenanceWindow{spec: spec}
+	fields := strings.Fields(spec)
+	if len(fields) != 6 {
+		return w, fmt.Errorf("maintenance '%s' needs a schedule and a duration", spec)
+	}
+	d, err := time.ParseDuration(fields[5])
+	if err != nil || d <= 0 || d > maxMaintenanceWindow {
+		return w, fmt.Errorf("maintenance '%s': invalid duration", spec)
+	}
+	w.duration = d
+	w.schedule, err = parseCron(strings.Join(fields[:5], " "))
+	return w, err
+}
+
+// activeUntil returns the end of the window containing t, the zero time if
+// there is none.
+func (w maintenanceWindow) activeUntil(t time.Time) time.Time {
+	start := t.Truncate(time.Minute)
+	for s := start; t.Sub(s) < w.duration; s = s.Add(-time.Minute) {
+		if w.schedule.matches(s) {
+			return s.Add(w.duration)
+		}
+	}
+	return time.Time{}
+}
+
+func parseMaintenanceWindows(m *machine) error {
+	m.windows = nil
+	for _, spec := range m.Maintenance {
+		w, err := parseMaintenance(spec)
+		if err != nil {
+			return fmt.Errorf("%s: %s", m.Name, err.Error())
+		}
+		m.windows = append(m.windows, w)
+	}
+	return nil
+}
+
+// maintenanceUntil returns the end of the current maintenance of the
+// machine, set from the TUI or by a scheduled window.
+func maintenanceUntil(m *machine) time.Time {
+	maintenanceMutex.Lock()
+	defer maintenanceMutex.Unlock()
+	return maintenanceEnd(m, time.Now())
+}
+
+// maintenanceEnd is maintenanceUntil for callers holding maintenanceMutex.
+func maintenanceEnd(m *machine, now time.Time) time.Time {
+	until := time.Time{}
+	if m.maintenance.After(now) {
+		until = m.maintenance
+	}
+	for _, w := range m.windows {
+		if end := w.activeUntil(now); end.After(until) {
+			until = end
+		}
+	}
+	return until
+}
+
+// updateMaintenance refreshes the maintenance flag of the machine, it is
+// kept so that sorting does not evaluate the schedules. It returns whether
+// the flag changed.
+func updateMaintenance(m *machine) bool {
+	maintenanceMutex.Lock()
+	defer maintenanceMutex.Unlock()
+	was := m.InMaintenance
+	m.InMaintenance = !maintenanceEnd(m, time.Now()).IsZero()
+	return m.InMaintenance != was
+}
+
+func inMaintenance(m *machine) bool {
+	maintenanceMutex.Lock()
+	defer maintenanceMutex.Unlock()
+	return m.InMaintenance
+}
+
+// maintenanceRoutine updates the maintenance flags when a window may start or
+// end, windows start on full minutes and maintenance set with the key ends
+// at any time. The changed machines are formatted by the sorting routine.
+func maintenanceRoutine() {
+	for {
+		now := time.Now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		maintenanceMutex.Lock()
+		for _, m := range machines {
+			if m.maintenance.After(now) && m.maintenance.Before(next) {
+				next = m.maintenance
+			}
+		}
+		maintenanceMutex.Unlock()
+		time.Sleep(next.Sub(now))
+		changed := false
+		for name, m := range machines {
+			if updateMaintenance(m) {
+				maintenanceMutex.Lock()
+				maintenanceChanged[name] = true
+				maintenanceMutex.Unlock()
+				changed = true
+			}
+		}
+		if changed {
+			sendSortingRequest()
+		}
+	}
+}
+
+// formatMaintenanceChanges formats the machines maintenanceRoutine changed.
+func formatMaintenanceChanges() {
+	maintenanceMutex.Lock()
+	changed := maintenanceChanged
+	maintenanceChanged = make(map[string]bool)
+	maintenanceMutex.Unlock()
+	for name := range changed {
+		formatMachine(name)
+	}
+}
+
+// sortStatus is the status a machine is sorted by, machines in maintenance
+// are sorted among the OK ones.
+func sortStatus(m *machine) int {
+	if inMaintenance(m) {
+		return statusOK
+	}
+	return m.Status
+}
+
+func openMaintenance(text string) {
+	openPrompt("maintenance <duration> [group] (0 ends): ", text, setMaintenance)
+}
+
+// setMaintenance puts the target machines, or all machines of the given
+// group, into maintenance for the duration.
+func setMaintenance(targets []string, text string) {
+	args := strings.Fields(text)
+	d, err := time.ParseDuration(args[0])
+	if err != nil || d < 0 {
+		setStatusMessage("maintenance: invalid duration '%s'", args[0])
+		return
+	}
+	if len(args) > 1 {
+		targets = nil
+		for name, m := range machines {
+			if contains(m.Groups, args[1]) {
+				targets = append(targets, name)
+			}
+		}
+	}
+	until := time.Now().Add(d)
+	for _, name := range targets {
+		m := machines[name]
+		maintenanceMutex.Lock()
+		m.maintenance = until
+		if d == 0 {
+			m.maintenance = time.Time{}
+		}
+		maintenanceMutex.Unlock()
+		updateMaintenance(m)
+		formatMachine(name)
+	}
+	if d == 0 {
+		setStatusMessage("maintenance ended for %d machines", len(targets))
+	} else {
+		setStatusMessage("%d machines in maintenance until %s", len(targets), until.Format("15:04"))
+	}
+	sendSortingRequest()
+}
+
+func maintenanceLines(m *machine) []styledText {
+	var lines []styledText
+	until := maintenanceUntil(m)
+	if until.IsZero() && len(m.windows) == 0 {
+		return lines
+	}
+	lines = append(lines, sectionLine("Maintenance"))
+	if !until.IsZero() {
+		lines = append(lines, textLine("    until "+until.Format("2006-01-02 15:04"), 4|termbox.AttrBold))
+	}
+	for _, w := range m.windows {
+		lines = append(lines, textLine("    schedule "+w.spec, termbox.ColorDefault))
+	}
+	return lines
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	for _, tc := range []struct {
+		field string
+		min   int
+		max   int
+		want  []int
+	}{
+		{"*", 1, 3, []int{1, 2, 3}},
+		{"5", 0, 59, []int{5}},
+		{"1-3,7", 0, 10, []int{1, 2, 3, 7}},
+		{"*/15", 0, 59, []int{0, 15, 30, 45}},
+		{"5/15", 0, 59, []int{5, 20, 35, 50}},
+		{"10-20/5", 0, 59, []int{10, 15, 20}},
+	} {
+		values, err := parseCronField(tc.field, tc.min, tc.max)
+		if err != nil {
+			t.Errorf("parseCronField(%q): %s", tc.field, err)
+			continue
+		}
+		if len(values) != len(tc.want) {
+			t.Errorf("parseCronField(%q) = %v, want %v", tc.field, values, tc.want)
+			continue
+		}
+		for _, v := range tc.want {
+			if !values[v] {
+				t.Errorf("parseCronField(%q) = %v, want %v", tc.field, values, tc.want)
+				break
+			}
+		}
+	}
+	for _, field := range []string{"", "x", "60", "5-1", "*/0", "1/x"} {
+		if _, err := parseCronField(field, 0, 59); err == nil {
+			t.Errorf("no error for %q", field)
+		}
+	}
+}
+
+func TestCronMatchesDays(t *testing.T) {
+	// 2026-03-01 is a Sunday, 2026-03-03 a Tuesday and 2026-03-15 a Sunday
+	sunday1 := time.Date(2026, 3, 1, 2, 0, 0, 0, time.Local)
+	tuesday3 := time.Date(2026, 3, 3, 2, 0, 0, 0, time.Local)
+	sunday15 := time.Date(2026, 3, 15, 2, 0, 0, 0, time.Local)
+	for _, tc := range []struct {
+		spec string
+		want [3]bool
+	}{
+		// a day field starting with * is not restricted, both have to match
+		{"0 2 * * 0", [3]bool{true, false, true}},
+		{"0 2 1 * *", [3]bool{true, false, false}},
+		{"0 2 */2 * 0", [3]bool{true, false, true}},
+		{"0 2 1 * */2", [3]bool{true, false, false}},
+		// both restricted: either one matches
+		{"0 2 3 * 0", [3]bool{true, true, true}},
+		{"0 2 * * 7", [3]bool{true, false, true}},
+		{"30 2 * * *", [3]bool{false, false, false}},
+	} {
+		c, err := parseCron(tc.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, day := range []time.Time{sunday1, tuesday3, sunday15} {
+			if got := c.matches(day); got != tc.want[i] {
+				t.Errorf("%q matches %s = %v, want %v", tc.spec, day.Format("Mon 02"), got, tc.want[i])
+			}
+		}
+	}
+	if _, err := parseCron("0 2 * *"); err == nil {
+		t.Errorf("no error for 4 fields")
+	}
+}
+
+func TestActiveUntil(t *testing.T) {
+	w, err := parseMaintenance("0 2 * * 0 2h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 3, 1, 2, 0, 0, 0, time.Local)
+	end := start.Add(2 * time.Hour)
+	for _, tc := range []struct {
+		at   time.Time
+		want time.Time
+	}{
+		{start.Add(-time.Second), time.Time{}},
+		{start, end},
+		{start.Add(90 * time.Minute), end},
+		{end.Add(-time.Second), end},
+		{end, time.Time{}},
+		{start.Add(24 * time.Hour), time.Time{}},
+	} {
+		if got := w.activeUntil(tc.at); !got.Equal(tc.want) {
+			t.Errorf("activeUntil(%s) = %s, want %s", tc.at.Format("Mon 15:04:05"), got, tc.want)
+		}
+	}
+	for _, spec := range []string{"0 2 * * 0", "0 2 * * 0 0", "0 2 * * 0 -1h", "0 2 * * 0 200h"} {
+		if _, err := parseMaintenance(spec); err == nil {
+			t.Errorf("no error for %q", spec)
+		}
+	}
+}
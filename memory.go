@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+type (
+	// memInfo holds the /proc/meminfo values in kB.
+	memInfo struct {
+		Total     int64
+		Available int64
+		Cached    int64
+		SwapTotal int64
+		SwapFree  int64
+	}
+
+	// pressure is the share of time in percent some tasks were stalled on a
+	// resource, averaged over 10, 60 and 300 seconds.
+	pressure struct {
+		Avg10  float32
+		Avg60  float32
+		Avg300 float32
+	}
+)
+
+const (
+	memInfoCmd  = `awk '{printf "%s%s ", $1, $2} END {print ""}' /proc/meminfo`
+	pressureCmd = `for r in cpu memory io; do if [ -r /proc/pressure/$r ]; then printf "%s: %s " $r "$(head -n 1 /proc/pressure/$r)"; fi; done; echo`
+)
+
+var pressureResources = []string{"cpu", "memory", "io"}
+
+// populateMemory parses the "Key:value" pairs of /proc/meminfo. The free
+// value is the share of memory that is not available for new allocations.
+func populateMemory(m *machine, line string) {
+	values := make(map[string]int64)
+	for _, field := range strings.Fields(line) {
+		kv := strings.SplitN(field, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v, err := strconv.ParseInt(kv[1], 10, 64)
+		if err == nil {
+			values[kv[0]] = v
+		}
+	}
+	total := values["MemTotal"]
+	if total == 0 {
+		m.Free.Value = float32(-1)
+		m.Swap.Value = nil
+		return
+	}
+	available, ok := values["MemAvailable"]
+	if !ok {
+		// kernels before 3.14
+		available = values["MemFree"] + values["Buffers"] + values["Cached"]
+	}
+	m.Memory = memInfo{
+		Total:     total,
+		Available: available,
+		Cached:    values["Cached"],
+		SwapTotal: values["SwapTotal"],
+		SwapFree:  values["SwapFree"],
+	}
+	m.Free.Value = float32(total-available) / float32(total)
+	if m.Memory.SwapTotal > 0 {
+		m.Swap.Value = float32(m.Memory.SwapTotal-m.Memory.SwapFree) / float32(m.Memory.SwapTotal)
+	} else {
+		m.Swap.Value = nil
+	}
+}
+
+// populatePressure parses the "some" lines of /proc/pressure, prefixed with
+// the resource name. Kernels without PSI give an empty line.
+func populatePressure(m *machine, line string) {
+	m.Pressure = make(map[string]pressure)
+	resource := ""
+	for _, field := range strings.Fields(line) {
+		if strings.HasSuffix(field, ":") {
+			resource = strings.TrimSuffix(field, ":")
+			m.Pressure[resource] = pressure{}
+			continue
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || len(resource) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(kv[1], 32)
+		if err != nil {
+			continue
+		}
+		p := m.Pressure[resource]
+		switch kv[0] {
+		case "avg10":
+			p.Avg10 = float32(v)
+		case "avg60":
+			p.Avg60 = float32(v)
+		case "avg300":
+			p.Avg300 = float32(v)
+		}
+		m.Pressure[resource] = p
+	}
+	for _, r := range pressureResources {
+		var value interface{}
+		if p, ok := m.Pressure[r]; ok {
+			value = p.Avg10
+		}
+		pressureMeasurement(m, r).Value = value
+	}
+}
+
+func pressureMeasurement(m *machine, resource string) *measurement {
+	switch resource {
+	case "cpu":
+		return &m.PressureCPU
+	case "memory":
+		return &m.PressureMem
+	}
+	return &m.PressureIO
+}
+
+func getSwapStatus(machine *machine) int {
+	return getValueStatus(machine.Swap, 0.5, 0.8)
+}
+
+func getPressureStatus(machine *machine, resource string) int {
+	switch resource {
+	case "cpu":
+		return getValueStatus(machine.PressureCPU, 50, 80)
+	case "memory":
+		return getValueStatus(machine.PressureMem, 10, 25)
+	}
+	return getValueStatus(machine.PressureIO, 20, 50)
+}
+
+func getPressuresStatus(machine *machine) int {
+	status := statusOK
+	for _, r := range pressureResources {
+		status |= getPressureStatus(machine, r)
+	}
+	return status
+}
+
+func memoryLines(m *machine) []styledText {
+	var lines []styledText
+	if m.Memory.Total == 0 {
+		return lines
+	}
+	lines = append(lines, sectionLine("Memory"))
+	kb := func(v int64) string {
+		return formatBytes(v * 1024)
+	}
+	lines = append(lines,
+		textLine(fmt.Sprintf("    %-10s %s", "total", kb(m.Memory.Total)), termbox.ColorDefault),
+		textLine(fmt.Sprintf("    %-10s %s", "available", kb(m.Memory.Available)), termbox.ColorDefault),
+		textLine(fmt.Sprintf("    %-10s %s", "cached", kb(m.Memory.Cached)), termbox.ColorDefault),
+	)
+	if m.Memory.SwapTotal > 0 {
+		fg := termbox.Attribute(termbox.ColorDefault)
+		if status := getSwapStatus(m); status != statusOK {
+			fg = statusColor(status)
+		}
+		lines = append(lines, textLine(fmt.Sprintf("    %-10s %s / %s", "swap used", kb(m.Memory.SwapTotal-m.Memory.SwapFree), kb(m.Memory.SwapTotal)), fg))
+	} else {
+		lines = append(lines, textLine(fmt.Sprintf("    %-10s %s", "swap", "none"), 9))
+	}
+	if len(m.Pressure) == 0 {
+		return lines
+	}
+	lines = append(lines, newStyledText(), sectionLine("Pressure (avg10 avg60 avg300)"))
+	for _, r := range pressureResources {
+		p, ok := m.Pressure[r]
+		if !ok {
+			continue
+		}
+		fg := termbox.Attribute(termbox.ColorDefault)
+		if status := getPressureStatus(m, r); status != statusOK {
+			fg = statusColor(status)
+		}
+		lines = append(lines, textLine(fmt.Sprintf("    %-10s %6.2f %6.2f %6.2f", r, p.Avg10, p.Avg60, p.Avg300), fg))
+	}
+	return lines
+}
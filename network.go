@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+type peer struct {
+	host  string
+	count int32
+}
+
+const (
+	listenState = "LISTEN"
+	listenPorts = "LISTEN_PORTS"
+	peersKey    = "PEERS"
+	maxPeers    = 10
+)
+
+// connStates lists the TCP states shown in the detail view, other states are
+// only counted into the total.
+var connStates = []string{"ESTABLISHED", "TIME_WAIT", "CLOSE_WAIT", "SYN_RECV", "SYN_SENT", "FIN_WAIT1", "FIN_WAIT2", "LAST_ACK", "CLOSING"}
+
+// normalizeState maps ss and BSD netstat state names to the Linux netstat
+// ones.
+func normalizeState(state string) string {
+	state = strings.Replace(strings.ToUpper(state), "-", "_", -1)
+	switch state {
+	case "ESTAB":
+		return "ESTABLISHED"
+	case "FIN_WAIT_1":
+		return "FIN_WAIT1"
+	case "FIN_WAIT_2":
+		return "FIN_WAIT2"
+	case "SYN_RCVD":
+		return "SYN_RECV"
+	}
+	return state
+}
+
+// populateConnections parses the output of connsCmd, a line of STATE=count
+// pairs followed by the listening ports and the remote peers with their
+// connection counts. The total excludes listening sockets.
+func populateConnections(m *machine, line string) {
+	states := make(map[string]int32)
+	var ports []int
+	var peers []peer
+	total := int32(0)
+	for _, field := range strings.Fields(line) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case listenPorts:
+			for _, p := range strings.Split(kv[1], ",") {
+				if port, err := strconv.Atoi(p); err == nil {
+					ports = append(ports, port)
+				}
+			}
+		case peersKey:
+			for _, p := range strings.Split(kv[1], ",") {
+				idx := strings.LastIndex(p, "/")
+				if idx < 0 {
+					continue
+				}
+				if count, err := strconv.ParseInt(p[idx+1:], 10, 32); err == nil {
+					peers = append(peers, peer{host: p[:idx], count: int32(count)})
+				}
+			}
+		default:
+			count, err := strconv.ParseInt(kv[1], 10, 32)
+			if err != nil {
+				continue
+			}
+			state := normalizeState(kv[0])
+			states[state] += int32(count)
+			if state != listenState {
+				total += int32(count)
+			}
+		}
+	}
+	if len(states) == 0 {
+		m.Connections.Value = int32(-1)
+	} else {
+		m.Connections.Value = total
+	}
+	sort.Ints(ports)
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].count == peers[j].count {
+			return peers[i].host < peers[j].host
+		}
+		return peers[i].count > peers[j].count
+	})
+	m.ConnStates = states
+	m.ListenPorts = ports
+	m.Peers = peers
+}
+
+func getStateLimit(machine *machine, state string) (measurement, bool) {
+	for k, limit := range machine.StateLimits {
+		if normalizeState(k) == state {
+			return limit, true
+		}
+	}
+	return measurement{}, false
+}
+
+// getConnStateStatus checks the count of one state against its limits from
+// conn_states, states without limits are always OK.
+func getConnStateStatus(machine *machine, state string) int {
+	limit, ok := getStateLimit(machine, state)
+	if !ok {
+		return statusOK
+	}
+	count := machine.ConnStates[state]
+	if err, ok := limit.Error.(float64); ok && count >= int32(err) {
+		return statusError
+	}
+	if warn, ok := limit.Warning.(float64); ok && count >= int32(warn) {
+		return statusWarning
+	}
+	return statusOK
+}
+
+func getConnStatesStatus(machine *machine) int {
+	status := statusOK
+	for state := range machine.StateLimits {
+		status |= getConnStateStatus(machine, normalizeState(state))
+	}
+	return status
+}
+
+func connectionLines(m *machine) []styledText {
+	var lines []styledText
+	if len(m.ConnStates) == 0 {
+		return lines
+	}
+	lines = append(lines, sectionLine("Connections"))
+	for _, state := range connStates {
+		if count, ok := m.ConnStates[state]; ok {
+			fg := termbox.Attribute(termbox.ColorDefault)
+			if status := getConnStateStatus(m, state); status != statusOK {
+				fg = statusColor(status)
+			}
+			lines = append(lines, textLine(fmt.Sprintf("    %-12s %d", state, count), fg))
+		}
+	}
+	if len(m.ListenPorts) > 0 {
+		var ports []string
+		for _, p := range m.ListenPorts {
+			ports = append(ports, strconv.Itoa(p))
+		}
+		lines = append(lines, textLine("    listening    "+strings.Join(ports, " "), termbox.ColorDefault))
+	}
+	if len(m.Peers) > 0 {
+		lines = append(lines, textLine("    top peers", 9|termbox.AttrBold))
+		for i, p := range m.Peers {
+			if i == maxPeers {
+				break
+			}
+			lines = append(lines, textLine(fmt.Sprintf("    %6d  %s", p.count, p.host), termbox.ColorDefault))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/madislohmus/gosh"
+	"github.com/nsf/termbox-go"
+	"golang.org/x/crypto/ssh"
+)
+
+type commandResult struct {
+	machine string
+	output  string
+	err     error
+	done    bool
+}
+
+const (
+	targetSelected = iota
+	targetFiltered
+	targetMarked
+
+	paletteTimeout = 30 * time.Second
+	maxHistory     = 100
+)
+
+var (
+	palette        bool
+	palettePrompt  string
+	paletteRun     func(targets []string, text string)
+	paletteInput   string
+	paletteTarget  int
+	paletteHistory []string
+	historyIndex   int
+
+	// paletteHistories keeps the history of every prompt, paletteHistory is
+	// the one of the open prompt.
+	paletteHistories = make(map[string][]string)
+
+	resultMutex sync.Mutex
+
+	targetNames = []string{"selected", "filtered", "marked"}
+)
+
+func openPalette(text string) {
+	openPrompt(":", text, runRemoteCommand)
+}
+
+// openPrompt opens the palette prompt, run is called with the target
+// machines and the entered text. Every prompt has its own history.
+func openPrompt(prompt, text string, run func(targets []string, text string)) {
+	palettePrompt = prompt
+	paletteHistory = paletteHistories[prompt]
+	paletteRun = run
+	if len(marked) > 0 {
+		paletteTarget = targetMarked
+	} else if paletteTarget == targetMarked {
+		paletteTarget = targetSelected
+	}
+	palette = true
+	paletteInput = text
+	historyIndex = len(paletteHistory)
+}
+
+func closePalette() {
+	palette = false
+	paletteInput = ""
+	termbox.HideCursor()
+}
+
+// getTargetMachines returns the names of the machines the palette command is
+// run on, in the order they are shown in the list.
+func getTargetMachines() []string {
+	switch paletteTarget {
+	case targetFiltered:
+		return getVisibleMachines()
+	case targetMarked:
+		return getMarkedMachines()
+	}
+	return []string{getSelectedMachine().Name}
+}
+
+func getVisibleMachines() []string {
+	var names []string
+	for _, k := range sorter.keys {
+		if !search || len(searchString) == 0 || matchingMachines[k] {
+			names = append(names, k)
+		}
+	}
+	return names
+}
+
+func handlePaletteKey(ev termbox.Event) {
+	switch ev.Key {
+	case termbox.KeyEsc:
+		closePalette()
+	case termbox.KeyEnter:
+		text := strings.TrimSpace(paletteInput)
+		closePalette()
+		targets := getTargetMachines()
+		if len(text) > 0 && len(targets) > 0 {
+			addToHistory(text)
+			paletteRun(targets, text)
+		}
+	case termbox.KeyTab:
+		paletteTarget = (paletteTarget + 1) % len(targetNames)
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if len(paletteInput) > 0 {
+			r := []rune(paletteInput)
+			paletteInput = string(r[:len(r)-1])
+		} else {
+			closePalette()
+		}
+	case termbox.KeyArrowUp:
+		if historyIndex > 0 {
+			historyIndex--
+			paletteInput = paletteHistory[historyIndex]
+		}
+	case termbox.KeyArrowDown:
+		if historyIndex < len(paletteHistory)-1 {
+			historyIndex++
+			paletteInput = paletteHistory[historyIndex]
+		} else {
+			historyIndex = len(paletteHistory)
+			paletteInput = ""
+		}
+	case termbox.KeySpace:
+		paletteInput += " "
+	default:
+		if ev.Ch != 0 {
+			paletteInput += string(ev.Ch)
+		}
+	}
+	sendRedrawRequest()
+}
+
+func addToHistory(cmd string) {
+	if len(paletteHistory) == 0 || paletteHistory[len(paletteHistory)-1] != cmd {
+		paletteHistory = append(paletteHistory, cmd)
+	}
+	if len(paletteHistory) > maxHistory {
+		paletteHistory = paletteHistory[1:]
+	}
+	paletteHistories[palettePrompt] = paletteHistory
+}
+
+func drawPalette() {
+	w, h := termbox.Size()
+	for j := 0; j < w; j++ {
+		termbox.SetCell(j, h-1, ' ', termbox.ColorDefault, termbox.ColorDefault)
+	}
+	prompt := fmt.Sprintf("[%s] %s", targetNames[paletteTarget], palettePrompt)
+	x := 1
+	for _, r := range prompt {
+		termbox.SetCell(x, h-1, r, 9, termbox.ColorDefault)
+		x++
+	}
+	for _, r := range paletteInput {
+		termbox.SetCell(x, h-1, r, termbox.ColorDefault, termbox.ColorDefault)
+		x++
+	}
+	termbox.SetCursor(x, h-1)
+}
+
+// getMachineClient returns the machine's ssh client, connecting if there is
+// none yet or reconnect is set. The status fetch and all commands share the
+// client.
+func getMachineClient(m *machine, reconnect bool) (*ssh.Client, error) {
+	m.clientMutex.Lock()
+	defer m.clientMutex.Unlock()
+	if m.client != nil && !reconnect {
+		return m.client, nil
+	}
+	client, err := gosh.GetClient(*m.config, 15*time.Second)
+	if err != nil {
+		m.disconnected = true
+		return nil, err
+	}
+	if m.disconnected {
+		logEvent(m, statusOK, "ssh", "reconnected")
+	}
+	m.disconnected = false
+	m.client = client
+	return client, nil
+}
+
+// dropClient forgets the client so that the next command reconnects, unless
+// it has been replaced already.
+func dropClient(m *machine, client *ssh.Client) {
+	m.clientMutex.Lock()
+	if m.client == client {
+		m.client = nil
+		m.disconnected = true
+	}
+	m.clientMutex.Unlock()
+}
+
+// isConnectionError tells whether the error means that the connection of the
+// client is gone.
+func isConnectionError(err error) bool {
+	op, ok := err.(*net.OpError)
+	return ok && !op.Timeout()
+}
+
+// runRemoteCommand runs the command on all given machines at once and shows
+// the results in a pane as they come in.
+func runRemoteCommand(names []string, cmd string) {
+	results := make([]*commandResult, len(names))
+	for i, name := range names {
+		results[i] = &commandResult{machine: name}
+	}
+	grouped := len(names) > 1
+	openPane(&pane{
+		title: func() styledText {
+			return textLine(fmt.Sprintf("%s (%s)", cmd, resultSummary(results)), termbox.ColorDefault|termbox.AttrBold)
+		},
+		lines: func() []styledText {
+			if grouped {
+				return groupedResultLines(results)
+			}
+			return resultLines(results)
+		},
+		hint: "Esc: back  g: group identical output",
+		onKey: func(ev termbox.Event) bool {
+			if ev.Ch == 'g' {
+				grouped = !grouped
+				return true
+			}
+			return false
+		},
+	})
+	for _, r := range results {
+		go func(r *commandResult) {
+			output, err := runOnMachine(machines[r.machine], cmd)
+			resultMutex.Lock()
+			r.output = output
+			r.err = err
+			r.done = true
+			resultMutex.Unlock()
+			sendRedrawRequest()
+		}(r)
+	}
+}
+
+func runOnMachine(m *machine, cmd string) (string, error) {
+	client, err := getMachineClient(m, false)
+	if err != nil {
+		return "", err
+	}
+	output, err := gosh.RunOnClient(cmd, *client, paletteTimeout)
+	if isConnectionError(err) {
+		dropClient(m, client)
+	}
+	return output, err
+}
+
+func resultStatus(r *commandResult) (string, termbox.Attribute) {
+	if !r.done {
+		return "running...", termbox.ColorGreen | termbox.AttrBold
+	}
+	if r.err == nil {
+		return "exit 0", 3
+	}
+	if exitErr, ok := r.err.(*ssh.ExitError); ok {
+		return fmt.Sprintf("exit %d", exitErr.ExitStatus()), 2 | termbox.AttrBold
+	}
+	return r.err.Error(), termbox.ColorRed
+}
+
+func resultSummary(results []*commandResult) string {
+	resultMutex.Lock()
+	defer resultMutex.Unlock()
+	running, failed := 0, 0
+	for _, r := range results {
+		if !r.done {
+			running++
+		} else if r.err != nil {
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d machines, %d running, %d failed", len(results), running, failed)
+}
+
+// groupedResultLines shows machines with identical output and exit status
+// once, like dshbak -c.
+func groupedResultLines(results []*commandResult) []styledText {
+	resultMutex.Lock()
+	defer resultMutex.Unlock()
+	type group struct {
+		machines []string
+		output   string
+		status   string
+		fg       termbox.Attribute
+	}
+	var groups []*group
+	byKey := make(map[string]*group)
+	for _, r := range results {
+		status, fg := resultStatus(r)
+		key := status + "\x00" + r.output
+		g, ok := byKey[key]
+		if !ok {
+			g = &group{output: r.output, status: status, fg: fg}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.machines = append(g.machines, r.machine)
+	}
+	var lines []styledText
+	for i, g := range groups {
+		if i > 0 {
+			lines = append(lines, newStyledText())
+		}
+		header := textLine(fmt.Sprintf("%s (%d) ", strings.Join(g.machines, ", "), len(g.machines)), termbox.ColorDefault|termbox.AttrBold)
+		appendStyled(&header, textLine(g.status, g.fg))
+		lines = append(lines, header)
+		if len(g.output) > 0 {
+			for _, l := range strings.Split(strings.TrimRight(g.output, "\n"), "\n") {
+				lines = append(lines, textLine("  "+l, termbox.ColorDefault))
+			}
+		}
+	}
+	return lines
+}
+
+func resultLines(results []*commandResult) []styledText {
+	resultMutex.Lock()
+	defer resultMutex.Unlock()
+	var lines []styledText
+	for i, r := range results {
+		if i > 0 {
+			lines = append(lines, newStyledText())
+		}
+		status, fg := resultStatus(r)
+		header := textLine(r.machine+" ", termbox.ColorDefault|termbox.AttrBold)
+		appendStyled(&header, textLine(status, fg))
+		lines = append(lines, header)
+		if len(r.output) > 0 {
+			for _, l := range strings.Split(strings.TrimRight(r.output, "\n"), "\n") {
+				lines = append(lines, textLine("  "+l, termbox.ColorDefault))
+			}
+		}
+	}
+	return lines
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/nsf/termbox-go"
+)
+
+// pane is a full screen scrollable view that is shown instead of the machine
+// list. lines is called on every redraw so the content can change while the
+// pane is open. Searchable panes can be filtered to the lines containing a
+// text with /. The hint may be changed from other goroutines with setHint.
+type pane struct {
+	title      func() styledText
+	lines      func() []styledText
+	hint       string
+	offset     int
+	follow     bool
+	searchable bool
+	filter     string
+	filtering  bool
+	onKey      func(ev termbox.Event) bool
+	onClose    func()
+	hintMutex  sync.Mutex
+}
+
+var activePane *pane
+
+func openPane(p *pane) {
+	activePane = p
+	sendRedrawRequest()
+}
+
+func closePane() {
+	p := activePane
+	activePane = nil
+	if p != nil && p.onClose != nil {
+		p.onClose()
+	}
+	sendRedrawRequest()
+}
+
+func (p *pane) setHint(hint string) {
+	p.hintMutex.Lock()
+	p.hint = hint
+	p.hintMutex.Unlock()
+}
+
+func (p *pane) getHint() string {
+	p.hintMutex.Lock()
+	defer p.hintMutex.Unlock()
+	return p.hint
+}
+
+func paneSize() int {
+	_, h := termbox.Size()
+	return h - 1 - headerRow
+}
+
+func drawPane(p *pane) {
+	w, h := termbox.Size()
+	if p.title != nil {
+		title := p.title()
+		for j := 0; j < len(title.Runes) && j < w-2; j++ {
+			termbox.SetCell(1+j, dateRow, title.Runes[j], title.FG[j], title.BG[j])
+		}
+	}
+	lines := p.filteredLines()
+	pageSize := paneSize()
+	if p.follow || p.offset > len(lines)-pageSize {
+		p.offset = len(lines) - pageSize
+	}
+	if p.offset < 0 {
+		p.offset = 0
+	}
+	for i := p.offset; i < len(lines) && i-p.offset < pageSize; i++ {
+		s := lines[i]
+		for j := 0; j < len(s.Runes) && j < w-2; j++ {
+			termbox.SetCell(1+j, headerRow+i-p.offset, s.Runes[j], s.FG[j], s.BG[j])
+		}
+	}
+	hint := p.getHint()
+	if len(hint) == 0 {
+		hint = "Esc: back"
+	}
+	fg := termbox.Attribute(9)
+	if p.filtering || len(p.filter) > 0 {
+		hint = "filter: " + p.filter
+		fg = 2
+	}
+	for i, r := range hint {
+		termbox.SetCell(i+1, h-1, r, fg, termbox.ColorDefault)
+	}
+	if p.filtering {
+		termbox.SetCursor(len(hint)+1, h-1)
+	}
+}
+
+// filteredLines returns the lines containing the filter with the matches
+// highlighted.
+func (p *pane) filteredLines() []styledText {
+	lines := p.lines()
+	if len(p.filter) == 0 {
+		return lines
+	}
+	filter := []rune(strings.ToLower(p.filter))
+	var filtered []styledText
+	for _, l := range lines {
+		idx := strings.Index(strings.ToLower(string(l.Runes)), string(filter))
+		if idx < 0 {
+			continue
+		}
+		idx = len([]rune(strings.ToLower(string(l.Runes))[:idx]))
+		s := newStyledText()
+		appendStyled(&s, l)
+		for j := idx; j < idx+len(filter) && j < len(s.Runes); j++ {
+			s.FG[j] = termbox.ColorBlack
+			s.BG[j] = termbox.ColorYellow
+		}
+		filtered = append(filtered, s)
+	}
+	return filtered
+}
+
+func handlePaneFilterKey(p *pane, ev termbox.Event) {
+	switch ev.Key {
+	case termbox.KeyEsc:
+		p.filter = ""
+		p.filtering = false
+	case termbox.KeyEnter:
+		p.filtering = false
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if len(p.filter) > 0 {
+			r := []rune(p.filter)
+			p.filter = string(r[:len(r)-1])
+		} else {
+			p.filtering = false
+		}
+	case termbox.KeySpace:
+		p.filter += " "
+	default:
+		if ev.Ch != 0 {
+			p.filter += string(ev.Ch)
+		}
+	}
+	if !p.filtering {
+		termbox.HideCursor()
+	}
+	sendRedrawRequest()
+}
+
+func handlePaneKey(p *pane, ev termbox.Event) {
+	if p.filtering {
+		handlePaneFilterKey(p, ev)
+		return
+	}
+	if p.searchable && ev.Ch == '/' {
+		p.filtering = true
+		sendRedrawRequest()
+		return
+	}
+	if p.onKey != nil && p.onKey(ev) {
+		sendRedrawRequest()
+		return
+	}
+	pageSize := paneSize()
+	switch ev.Key {
+	case termbox.KeyEsc:
+		if len(p.filter) > 0 {
+			p.filter = ""
+			break
+		}
+		closePane()
+		return
+	case termbox.KeyArrowUp:
+		p.offset--
+	case termbox.KeyArrowDown:
+		p.offset++
+	case termbox.KeyPgup:
+		p.offset -= pageSize
+	case termbox.KeyPgdn:
+		p.offset += pageSize
+	case termbox.KeyHome:
+		p.offset = 0
+	case termbox.KeyEnd:
+		p.offset = len(p.lines())
+	}
+	// following is on whenever the last line is shown, so scrolling back
+	// down resumes it
+	n := len(p.filteredLines())
+	if p.offset > n-pageSize {
+		p.offset = n - pageSize
+	}
+	if p.offset < 0 {
+		p.offset = 0
+	}
+	p.follow = p.offset >= n-pageSize
+	sendRedrawRequest()
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+type (
+	process struct {
+		pid     int
+		user    string
+		cpu     float64
+		mem     float64
+		rss     int64
+		command string
+	}
+
+	// processView keeps the process list of one machine up to date while its
+	// pane is open.
+	processView struct {
+		machine   *machine
+		processes []process
+		err       error
+		byMemory  bool
+		selected  int
+		confirm   string
+		target    process
+		ticks     map[int]uint64
+		uptime    float64
+		pane      *pane
+		done      chan bool
+		mutex     sync.Mutex
+	}
+)
+
+const (
+	psCmd               = `ps -eo pid=,user=,pcpu=,pmem=,rss=,args=`
+	ticksCmd            = `; echo '==> ticks'; getconf CLK_TCK; cat /proc/uptime /proc/[0-9]*/stat 2>/dev/null; true`
+	processRefreshDelay = 2 * time.Second
+	processHint         = "Esc: back  c/m: sort by CPU/memory  k: SIGTERM  K: SIGKILL"
+)
+
+func openProcesses(string) {
+	v := &processView{machine: getSelectedMachine(), done: make(chan bool)}
+	v.pane = &pane{
+		title: func() styledText {
+			order := "CPU"
+			if v.byMemory {
+				order = "memory"
+			}
+			return textLine(fmt.Sprintf("%s: top processes by %s", v.machine.Name, order), termbox.ColorDefault|termbox.AttrBold)
+		},
+		lines:   v.lines,
+		hint:    processHint,
+		onKey:   v.handleKey,
+		onClose: func() { close(v.done) },
+	}
+	openPane(v.pane)
+	go v.refreshRoutine()
+}
+
+func (v *processView) refreshRoutine() {
+	for {
+		v.refresh()
+		select {
+		case <-v.done:
+			return
+		case <-time.After(processRefreshDelay):
+		}
+	}
+}
+
+// refresh fetches the process list. The %CPU of ps is the average over the
+// lifetime of a process, so where /proc is available the CPU ticks of every
+// process are fetched too and the usage is computed from the difference to
+// the previous refresh.
+func (v *processView) refresh() {
+	cmd := psCmd
+	if _, ok := osCollectors[v.machine.OS]; !ok {
+		cmd += ticksCmd
+	}
+	output, err := runOnMachine(v.machine, cmd)
+	sections := strings.SplitN(output, "\n"+sectionMarker+"ticks\n", 2)
+	processes := parseProcesses(sections[0])
+	var ticks map[int]uint64
+	var uptime, hz float64
+	if len(sections) > 1 {
+		ticks, uptime, hz = parseTicks(sections[1])
+	}
+	v.mutex.Lock()
+	v.err = err
+	if err == nil {
+		if v.ticks != nil && ticks != nil && uptime > v.uptime && hz > 0 {
+			for i, p := range processes {
+				t, ok := ticks[p.pid]
+				if ok && t >= v.ticks[p.pid] {
+					processes[i].cpu = float64(t-v.ticks[p.pid]) / hz / (uptime - v.uptime) * 100
+				}
+			}
+		}
+		v.processes = processes
+		v.ticks = ticks
+		v.uptime = uptime
+	}
+	v.sort()
+	v.mutex.Unlock()
+	sendRedrawRequest()
+}
+
+func parseProcesses(output string) []process {
+	var processes []process
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		p := process{pid: pid, user: fields[1], command: strings.Join(fields[5:], " ")}
+		p.cpu, _ = strconv.ParseFloat(fields[2], 64)
+		p.mem, _ = strconv.ParseFloat(fields[3], 64)
+		p.rss, _ = strconv.ParseInt(fields[4], 10, 64)
+		processes = append(processes, p)
+	}
+	return processes
+}
+
+// parseTicks parses the clock tick rate, /proc/uptime and the user and system
+// CPU ticks of every process from /proc/<pid>/stat.
+func parseTicks(output string) (map[int]uint64, float64, float64) {
+	lines := strings.Split(output, "\n")
+	if len(lines) < 2 {
+		return nil, 0, 0
+	}
+	hz, err := strconv.ParseFloat(strings.TrimSpace(lines[0]), 64)
+	if err != nil {
+		return nil, 0, 0
+	}
+	fields := strings.Fields(lines[1])
+	if len(fields) == 0 {
+		return nil, 0, 0
+	}
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, 0, 0
+	}
+	ticks := make(map[int]uint64)
+	for _, line := range lines[2:] {
+		// the command name in parentheses may contain spaces
+		i := strings.Index(line, " (")
+		j := strings.LastIndex(line, ")")
+		if i < 0 || j < i {
+			continue
+		}
+		pid, err := strconv.Atoi(line[:i])
+		if err != nil {
+			continue
+		}
+		f := strings.Fields(line[j+1:])
+		if len(f) < 13 {
+			continue
+		}
+		utime, _ := strconv.ParseUint(f[11], 10, 64)
+		stime, _ := strconv.ParseUint(f[12], 10, 64)
+		ticks[pid] = utime + stime
+	}
+	return ticks, uptime, hz
+}
+
+func (v *processView) sort() {
+	sort.SliceStable(v.processes, func(i, j int) bool {
+		if v.byMemory {
+			return v.processes[i].rss > v.processes[j].rss
+		}
+		return v.processes[i].cpu > v.processes[j].cpu
+	})
+}
+
+// visible returns the number of processes that fit the pane.
+func (v *processView) visible() int {
+	n := paneSize() - 2
+	if n > len(v.processes) {
+		n = len(v.processes)
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+func (v *processView) lines() []styledText {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	var lines []styledText
+	if v.err != nil {
+		lines = append(lines, textLine(v.err.Error(), termbox.ColorRed))
+	}
+	if v.processes == nil {
+		if v.err == nil {
+			lines = append(lines, textLine("fetching...", termbox.ColorGreen|termbox.AttrBold))
+		}
+		return lines
+	}
+	lines = append(lines, textLine(fmt.Sprintf("%7s %-10s %5s %5s %9s  %s", "PID", "USER", "%CPU", "%MEM", "RSS", "COMMAND"), 9|termbox.AttrBold))
+	n := v.visible()
+	if v.selected >= n {
+		v.selected = n - 1
+	}
+	for i := 0; i < n; i++ {
+		p := v.processes[i]
+		user := p.user
+		if len(user) > 10 {
+			user = user[:10]
+		}
+		l := textLine(fmt.Sprintf("%7d %-10s %5.1f %5.1f %9s  %s", p.pid, user, p.cpu, p.mem, formatBytes(p.rss*1024), p.command), termbox.ColorDefault)
+		if i == v.selected {
+			for j := range l.Runes {
+				l.FG[j] = selectedFg
+				l.BG[j] = selectedBg
+			}
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func (v *processView) handleKey(ev termbox.Event) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if len(v.confirm) > 0 {
+		if ev.Ch == 'y' {
+			go v.kill(v.target, v.confirm)
+		} else {
+			v.pane.setHint(processHint)
+		}
+		v.confirm = ""
+		return true
+	}
+	switch {
+	case ev.Key == termbox.KeyArrowUp:
+		if v.selected > 0 {
+			v.selected--
+		}
+	case ev.Key == termbox.KeyArrowDown:
+		if v.selected < v.visible()-1 {
+			v.selected++
+		}
+	case ev.Ch == 'c':
+		v.byMemory = false
+		v.sort()
+	case ev.Ch == 'm':
+		v.byMemory = true
+		v.sort()
+	case ev.Ch == 'k' || ev.Ch == 'K':
+		if v.selected < 0 || v.selected >= len(v.processes) {
+			return true
+		}
+		v.confirm = "TERM"
+		if ev.Ch == 'K' {
+			v.confirm = "KILL"
+		}
+		v.target = v.processes[v.selected]
+		v.pane.setHint(fmt.Sprintf("send SIG%s to %d (%s)? y/n", v.confirm, v.target.pid, v.target.command))
+	default:
+		return false
+	}
+	return true
+}
+
+func (v *processView) kill(p process, signal string) {
+	_, err := runOnMachine(v.machine, fmt.Sprintf("kill -%s %d", signal, p.pid))
+	if err != nil {
+		v.pane.setHint(fmt.Sprintf("kill %d: %s", p.pid, err.Error()))
+	} else {
+		v.pane.setHint(fmt.Sprintf("sent SIG%s to %d", signal, p.pid))
+	}
+	v.refresh()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+type (
+	diskRate struct {
+		Name       string
+		ReadIOPS   float64
+		WriteIOPS  float64
+		ReadBytes  float64
+		WriteBytes float64
+	}
+
+	netRate struct {
+		Name     string
+		RxBytes  float64
+		TxBytes  float64
+		RxErrors float64
+		TxErrors float64
+	}
+
+	// counterSample keeps the raw counters of the previous fetch, rates are
+	// computed from the difference to it.
+	counterSample struct {
+		time     time.Time
+		counters map[string][]uint64
+	}
+)
+
+const (
+	sectorSize   = 512
+	diskStatsCmd = `awk '{printf "%s:%s:%s:%s:%s ", $3, $4, $6, $8, $10} END {print ""}' /proc/diskstats`
+	netDevCmd    = `awk 'NR>2 {sub(":", " "); printf "%s:%s:%s:%s:%s ", $1, $2, $4, $10, $12} END {print ""}' /proc/net/dev`
+)
+
+// parseCounters parses "name:c1:c2:..." fields into counters by name.
+func parseCounters(line string) map[string][]uint64 {
+	counters := make(map[string][]uint64)
+	for _, field := range strings.Fields(line) {
+		parts := strings.Split(field, ":")
+		if len(parts) < 2 {
+			continue
+		}
+		values := make([]uint64, len(parts)-1)
+		ok := true
+		for i, p := range parts[1:] {
+			v, err := strconv.ParseUint(p, 10, 64)
+			if err != nil {
+				ok = false
+				break
+			}
+			values[i] = v
+		}
+		if ok {
+			counters[parts[0]] = values
+		}
+	}
+	return counters
+}
+
+// counterRates returns the per second rates of all counters present in both
+// samples. Counters that went backwards are skipped.
+func counterRates(prev *counterSample, counters map[string][]uint64, now time.Time) map[string][]float64 {
+	rates := make(map[string][]float64)
+	if prev == nil || prev.time.IsZero() {
+		return rates
+	}
+	seconds := now.Sub(prev.time).Seconds()
+	if seconds <= 0 {
+		return rates
+	}
+next:
+	for name, values := range counters {
+		old, ok := prev.counters[name]
+		if !ok || len(old) != len(values) {
+			continue
+		}
+		r := make([]float64, len(values))
+		for i := range values {
+			if values[i] < old[i] {
+				continue next
+			}
+			r[i] = float64(values[i]-old[i]) / seconds
+		}
+		rates[name] = r
+	}
+	return rates
+}
+
+// isWholeDisk skips loop and ram devices and partitions of other listed
+// devices (sda1 of sda, nvme0n1p1 of nvme0n1). Devices whose name ends in a
+// digit separate their partitions with p, so dm-10 is not a partition of
+// dm-1.
+func isWholeDisk(name string, devices map[string][]uint64) bool {
+	if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+		return false
+	}
+	for d := range devices {
+		if d == name || !strings.HasPrefix(name, d) {
+			continue
+		}
+		rest := strings.TrimPrefix(name, d)
+		if last := d[len(d)-1]; last >= '0' && last <= '9' {
+			if !strings.HasPrefix(rest, "p") {
+				continue
+			}
+			rest = rest[1:]
+		}
+		if _, err := strconv.Atoi(rest); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func populateDiskRates(m *machine, line string, now time.Time) {
+	counters := parseCounters(line)
+	for name := range counters {
+		if !isWholeDisk(name, counters) {
+			delete(counters, name)
+		}
+	}
+	rates := counterRates(m.diskSample, counters, now)
+	m.diskSample = &counterSample{time: now, counters: counters}
+	m.DiskRates = nil
+	for name, r := range rates {
+		m.DiskRates = append(m.DiskRates, diskRate{
+			Name:       name,
+			ReadIOPS:   r[0],
+			ReadBytes:  r[1] * sectorSize,
+			WriteIOPS:  r[2],
+			WriteBytes: r[3] * sectorSize,
+		})
+	}
+	sort.Slice(m.DiskRates, func(i, j int) bool { return m.DiskRates[i].Name < m.DiskRates[j].Name })
+	if len(m.DiskRates) == 0 {
+		m.DiskIOPS.Value = nil
+		m.DiskBytes.Value = nil
+		return
+	}
+	iops, bytes := float32(0), float32(0)
+	for _, d := range m.DiskRates {
+		iops = float32(math.Max(float64(iops), d.ReadIOPS+d.WriteIOPS))
+		bytes = float32(math.Max(float64(bytes), d.ReadBytes+d.WriteBytes))
+	}
+	m.DiskIOPS.Value = iops
+	m.DiskBytes.Value = bytes
+}
+
+func populateNetRates(m *machine, line string, now time.Time) {
+	counters := parseCounters(line)
+	delete(counters, "lo")
+	rates := counterRates(m.netSample, counters, now)
+	m.netSample = &counterSample{time: now, counters: counters}
+	m.NetRates = nil
+	for name, r := range rates {
+		m.NetRates = append(m.NetRates, netRate{
+			Name:     name,
+			RxBytes:  r[0],
+			RxErrors: r[1],
+			TxBytes:  r[2],
+			TxErrors: r[3],
+		})
+	}
+	sort.Slice(m.NetRates, func(i, j int) bool { return m.NetRates[i].Name < m.NetRates[j].Name })
+	if len(m.NetRates) == 0 {
+		m.NetBytes.Value = nil
+		m.NetErrors.Value = nil
+		return
+	}
+	bytes, errors := float32(0), float32(0)
+	for _, n := range m.NetRates {
+		bytes = float32(math.Max(float64(bytes), n.RxBytes+n.TxBytes))
+		errors += float32(n.RxErrors + n.TxErrors)
+	}
+	m.NetBytes.Value = bytes
+	m.NetErrors.Value = errors
+}
+
+// The throughput limits depend on the hardware, there are no defaults.
+func getDiskStatus(machine *machine) int {
+	return getValueStatus(machine.DiskIOPS, math.Inf(1), math.Inf(1)) |
+		getValueStatus(machine.DiskBytes, math.Inf(1), math.Inf(1))
+}
+
+func getNetStatus(machine *machine) int {
+	return getValueStatus(machine.NetBytes, math.Inf(1), math.Inf(1)) |
+		getValueStatus(machine.NetErrors, 1, 10)
+}
+
+// formatRate formats bytes per second in short form, e.g. 1.2M.
+func formatRate(b float64) string {
+	units := []string{"", "K", "M", "G", "T"}
+	i := 0
+	for b >= 1000 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f", b)
+	}
+	return fmt.Sprintf("%.1f%s", b, units[i])
+}
+
+func rateLines(m *machine) []styledText {
+	var lines []styledText
+	if len(m.DiskRates) > 0 {
+		lines = append(lines, sectionLine("Disk I/O"))
+		lines = append(lines, textLine(fmt.Sprintf("    %-12s %8s %8s %10s %10s", "device", "r/s", "w/s", "read/s", "write/s"), 9))
+		for _, d := range m.DiskRates {
+			lines = append(lines, textLine(fmt.Sprintf("    %-12s %8.1f %8.1f %10s %10s", d.Name, d.ReadIOPS, d.WriteIOPS, formatRate(d.ReadBytes), formatRate(d.WriteBytes)), termbox.ColorDefault))
+		}
+	}
+	if len(m.NetRates) > 0 {
+		if len(lines) > 0 {
+			lines = append(lines, newStyledText())
+		}
+		lines = append(lines, sectionLine("Network"))
+		lines = append(lines, textLine(fmt.Sprintf("    %-12s %10s %10s %8s %8s", "interface", "rx/s", "tx/s", "rxerr/s", "txerr/s"), 9))
+		for _, n := range m.NetRates {
+			fg := termbox.Attribute(termbox.ColorDefault)
+			if status := getValueStatus(m.NetErrors, 1, 10); n.RxErrors+n.TxErrors > 0 && status != statusOK {
+				fg = statusColor(status)
+			}
+			lines = append(lines, textLine(fmt.Sprintf("    %-12s %10s %10s %8.1f %8.1f", n.Name, formatRate(n.RxBytes), formatRate(n.TxBytes), n.RxErrors, n.TxErrors), fg))
+		}
+	}
+	return lines
+}
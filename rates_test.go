@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestIsWholeDisk(t *testing.T) {
+	for _, tc := range []struct {
+		devices []string
+		whole   map[string]bool
+	}{
+		{[]string{"sda", "sda1", "sda2"}, map[string]bool{"sda": true, "sda1": false, "sda2": false}},
+		{[]string{"nvme0n1", "nvme0n1p1"}, map[string]bool{"nvme0n1": true, "nvme0n1p1": false}},
+		{[]string{"dm-1", "dm-10"}, map[string]bool{"dm-1": true, "dm-10": true}},
+		{[]string{"md1", "md10"}, map[string]bool{"md1": true, "md10": true}},
+		{[]string{"nbd1", "nbd10"}, map[string]bool{"nbd1": true, "nbd10": true}},
+		{[]string{"loop0", "ram0", "vda"}, map[string]bool{"loop0": false, "ram0": false, "vda": true}},
+	} {
+		devices := make(map[string][]uint64)
+		for _, d := range tc.devices {
+			devices[d] = nil
+		}
+		for name, want := range tc.whole {
+			if got := isWholeDisk(name, devices); got != want {
+				t.Errorf("isWholeDisk(%q) with %v = %v, want %v", name, tc.devices, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+type (
+	// transfer keeps the progress of a push or pull over all its machines
+	// and shows it in the status bar.
+	transfer struct {
+		name    string
+		total   int
+		done    int
+		bytes   int64
+		errors  []string
+		updated time.Time
+		mutex   sync.Mutex
+	}
+
+	countingReader struct {
+		reader   io.Reader
+		transfer *transfer
+	}
+)
+
+// tmpSuffix is appended to the name a file is copied to before it is renamed
+// to its final name, so that a failed copy does not leave a partial file.
+const tmpSuffix = ".kone-tmp"
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.transfer.add(int64(n))
+	return n, err
+}
+
+func newTransfer(name string, total int) *transfer {
+	t := &transfer{name: name, total: total}
+	t.show()
+	return t
+}
+
+func (t *transfer) add(n int64) {
+	t.mutex.Lock()
+	t.bytes += n
+	update := time.Since(t.updated) > 200*time.Millisecond
+	t.mutex.Unlock()
+	if update {
+		t.show()
+	}
+}
+
+func (t *transfer) finish(machine string, err error) {
+	t.mutex.Lock()
+	t.done++
+	if err != nil {
+		t.errors = append(t.errors, machine+": "+err.Error())
+	}
+	t.mutex.Unlock()
+	t.show()
+}
+
+func (t *transfer) show() {
+	t.mutex.Lock()
+	t.updated = time.Now()
+	msg := fmt.Sprintf("%s: %d/%d machines, %s", t.name, t.done, t.total, formatBytes(t.bytes))
+	if len(t.errors) > 0 {
+		msg += fmt.Sprintf(", %d failed (%s)", len(t.errors), strings.Join(t.errors, "; "))
+	}
+	t.mutex.Unlock()
+	setStatusMessage("%s", msg)
+}
+
+func formatBytes(b int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	v := float64(b)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d %s", b, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+func openPush(text string) {
+	openPrompt("push <local> [remote]: ", text, pushFile)
+}
+
+func openPull(text string) {
+	openPrompt("pull <remote> [local dir]: ", text, pullFile)
+}
+
+func withSFTP(m *machine, f func(c *sftp.Client) error) error {
+	client, err := getMachineClient(m, false)
+	if err != nil {
+		return err
+	}
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return f(c)
+}
+
+// remotePath makes paths starting with ~/ relative, sftp paths are relative
+// to the home directory.
+func remotePath(p string) string {
+	return strings.TrimPrefix(p, "~/")
+}
+
+// pushFile copies a local file to all target machines. Without a remote path
+// the file is put to the home directory, a remote path ending with / is
+// treated as a directory. The file keeps its permissions.
+func pushFile(targets []string, text string) {
+	args := splitArgs(text)
+	local := expandHome(args[0])
+	remote := filepath.Base(local)
+	if len(args) > 1 {
+		remote = remotePath(args[1])
+		if strings.HasSuffix(remote, "/") {
+			remote += filepath.Base(local)
+		}
+	}
+	t := newTransfer("push "+filepath.Base(local), len(targets))
+	for _, name := range targets {
+		go func(m *machine) {
+			t.finish(m.Name, withSFTP(m, func(c *sftp.Client) error {
+				src, err := os.Open(local)
+				if err != nil {
+					return err
+				}
+				defer src.Close()
+				info, err := src.Stat()
+				if err != nil {
+					return err
+				}
+				tmp := remote + tmpSuffix
+				dst, err := c.Create(tmp)
+				if err != nil {
+					return err
+				}
+				_, err = io.Copy(dst, &countingReader{reader: src, transfer: t})
+				if cerr := dst.Close(); err == nil {
+					err = cerr
+				}
+				if err == nil {
+					err = c.Chmod(tmp, info.Mode().Perm())
+				}
+				if err == nil {
+					err = c.PosixRename(tmp, remote)
+				}
+				if err != nil {
+					c.Remove(tmp)
+				}
+				return err
+			}))
+		}(machines[name])
+	}
+}
+
+// pullFile copies a remote file from all target machines to the local
+// directory (current directory by default). When pulling from several
+// machines every machine gets its own sub directory.
+func pullFile(targets []string, text string) {
+	args := splitArgs(text)
+	remote := remotePath(args[0])
+	dir := "."
+	if len(args) > 1 {
+		dir = expandHome(args[1])
+	}
+	t := newTransfer("pull "+path.Base(remote), len(targets))
+	for _, name := range targets {
+		local := filepath.Join(dir, path.Base(remote))
+		if len(targets) > 1 {
+			local = filepath.Join(dir, name, path.Base(remote))
+		}
+		go func(m *machine, local string) {
+			t.finish(m.Name, withSFTP(m, func(c *sftp.Client) error {
+				src, err := c.Open(remote)
+				if err != nil {
+					return err
+				}
+				defer src.Close()
+				info, err := src.Stat()
+				if err != nil {
+					return err
+				}
+				if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+					return err
+				}
+				tmp := local + tmpSuffix
+				dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+				if err != nil {
+					return err
+				}
+				_, err = io.Copy(dst, &countingReader{reader: src, transfer: t})
+				if cerr := dst.Close(); err == nil {
+					err = cerr
+				}
+				if err == nil {
+					err = os.Chmod(tmp, info.Mode().Perm())
+				}
+				if err == nil {
+					err = os.Rename(tmp, local)
+				}
+				if err != nil {
+					os.Remove(tmp)
+				}
+				return err
+			}))
+		}(machines[name], local)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+type (
+	// limits are the warning and error levels of a trend threshold, zero
+	// disables a level.
+	limits struct {
+		Warning float64 `json:"warning"`
+		Error   float64 `json:"error"`
+	}
+
+	// trend is the growth of a metric per hour from a linear regression
+	// over the history, FullIn is the time in hours until a usage metric
+	// reaches 100% or -1 when it is not growing.
+	trend struct {
+		Slope  float64
+		FullIn float64
+		Status int
+	}
+
+	// raisedLevel keeps since when a metric has been at warning and error
+	// level for thresholds with a minimum duration.
+	raisedLevel struct {
+		warnSince time.Time
+		errSince  time.Time
+	}
+)
+
+const (
+	trendWindow     = 6 * time.Hour
+	trendMinSamples = 3
+	trendMinSpan    = 15 * time.Minute
+)
+
+// usageMetrics are the percentage metrics full_in applies to.
+var usageMetrics = map[string]bool{"storage": true, "inode": true}
+
+func metricLimits(m *machine, metric string) measurement {
+	switch metric {
+	case "load1":
+		return m.Load1
+	case "cpu":
+		return m.CPU
+	case "free":
+		return m.Free
+	case "storage":
+		return m.Storage
+	case "inode":
+		return m.Inode
+	}
+	return m.Connections
+}
+
+// seriesValues splits a sample into the series a trend is computed for,
+// every mount of storage and inode usage has its own.
+func seriesValues(metric historyMetric, s sample) []float64 {
+	var values []int32
+	switch metric.name {
+	case "storage":
+		values = s.Storage
+	case "inode":
+		values = s.Inode
+	default:
+		return []float64{metric.value(s)}
+	}
+	series := make([]float64, len(values))
+	for i, v := range values {
+		series[i] = float64(v)
+	}
+	return series
+}
+
+// regression returns the slope per hour of the least squares line.
+func regression(times []time.Time, values []float64) float64 {
+	n := float64(len(values))
+	var sx, sy, sxx, sxy float64
+	for i, v := range values {
+		x := times[i].Sub(times[0]).Hours()
+		sx += x
+		sy += v
+		sxx += x * x
+		sxy += x * v
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / d
+}
+
+// rateStatus checks the slope against rate limits. Positive limits are
+// reached by growth and negative ones by decrease. free changes are bad in
+// both directions, so its positive limits are checked against the absolute
+// slope.
+func rateStatus(metric string, slope float64, l *limits) int {
+	if l == nil {
+		return statusOK
+	}
+	if metric == "free" && l.Error >= 0 && l.Warning >= 0 {
+		slope = math.Abs(slope)
+	}
+	rising := limitStatus(slope, &limits{Warning: math.Max(0, l.Warning), Error: math.Max(0, l.Error)}, false)
+	falling := limitStatus(slope, &limits{Warning: math.Min(0, l.Warning), Error: math.Min(0, l.Error)}, true)
+	return rising | falling
+}
+
+func limitStatus(value float64, l *limits, below bool) int {
+	if l == nil {
+		return statusOK
+	}
+	reached := func(limit float64) bool {
+		if limit == 0 {
+			return false
+		}
+		if below {
+			return value <= limit
+		}
+		return value >= limit
+	}
+	if reached(l.Error) {
+		return statusError
+	} else if reached(l.Warning) {
+		return statusWarning
+	}
+	return statusOK
+}
+
+// updateTrends computes the trends of the metrics with rate or full_in
+// limits from the recent history and the current values.
+func updateTrends(m *machine) {
+	now := currentSample(m)
+	history := append(getHistory(m), now)
+	start := now.Time.Add(-trendWindow)
+	for len(history) > 0 && history[0].Time.Before(start) {
+		history = history[1:]
+	}
+	trends := make(map[string]trend)
+	defer func() {
+		historyMutex.Lock()
+		m.Trends = trends
+		historyMutex.Unlock()
+	}()
+	if len(history) < trendMinSamples || now.Time.Sub(history[0].Time) < trendMinSpan {
+		return
+	}
+	for _, metric := range historyMetrics {
+		l := metricLimits(m, metric.name)
+		if l.Rate == nil && (l.FullIn == nil || !usageMetrics[metric.name]) {
+			continue
+		}
+		current := seriesValues(metric, now)
+		worst := trend{FullIn: -1, Status: statusOK}
+		for i := range current {
+			var times []time.Time
+			var values []float64
+			for _, s := range history {
+				// mounts may have changed, only samples with the same
+				// mounts are comparable
+				if v := seriesValues(metric, s); len(v) == len(current) {
+					times = append(times, s.Time)
+					values = append(values, v[i])
+				}
+			}
+			if len(values) < trendMinSamples {
+				continue
+			}
+			t := trend{Slope: regression(times, values), FullIn: -1}
+			t.Status = rateStatus(metric.name, t.Slope, l.Rate)
+			if usageMetrics[metric.name] && t.Slope > 0 {
+				t.FullIn = math.Max(0, (100-current[i])/t.Slope)
+				t.Status |= limitStatus(t.FullIn, l.FullIn, true)
+			}
+			t.Status = worstStatus(t.Status)
+			if t.Status > worst.Status || (t.Status == worst.Status && math.Abs(t.Slope) > math.Abs(worst.Slope)) {
+				worst = t
+			}
+		}
+		trends[metric.name] = worst
+	}
+}
+
+// getTrend returns the trend of the metric, the map is replaced by the fetch
+// goroutine while the screen reads it.
+func getTrend(m *machine, metric string) (trend, bool) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	t, ok := m.Trends[metric]
+	return t, ok
+}
+
+func getTrendsStatus(m *machine) int {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	status := statusOK
+	for _, t := range m.Trends {
+		status |= t.Status
+	}
+	return status
+}
+
+// worstStatus reduces combined status bits to the worst one.
+func worstStatus(status int) int {
+	if status&statusError > 0 {
+		return statusError
+	} else if status&statusWarning > 0 {
+		return statusWarning
+	}
+	return statusOK
+}
+
+// sustained lowers the status of a metric whose limits have a "for"
+// duration until the metric has been at that level for the whole duration.
+func sustained(m *machine, metric string, status int) int {
+	l := metricLimits(m, metric)
+	if l.For <= 0 {
+		return status
+	}
+	if m.raised == nil {
+		m.raised = make(map[string]*raisedLevel)
+	}
+	r, ok := m.raised[metric]
+	if !ok {
+		r = &raisedLevel{}
+		m.raised[metric] = r
+	}
+	now := time.Now()
+	level := worstStatus(status)
+	if level == statusOK {
+		r.warnSince = time.Time{}
+	} else if r.warnSince.IsZero() {
+		r.warnSince = now
+	}
+	if level != statusError {
+		r.errSince = time.Time{}
+	} else if r.errSince.IsZero() {
+		r.errSince = now
+	}
+	duration := time.Duration(l.For * float64(time.Minute))
+	if !r.errSince.IsZero() && now.Sub(r.errSince) >= duration {
+		return statusError
+	} else if !r.warnSince.IsZero() && now.Sub(r.warnSince) >= duration {
+		return statusWarning
+	}
+	return statusOK
+}
+
+// formatTrend describes a trend in short form, e.g. "+2.1/h full 5h".
+func formatTrend(t trend, format string) string {
+	text := fmt.Sprintf("%+"+format[1:]+"/h", t.Slope)
+	if t.FullIn >= 0 {
+		text += fmt.Sprintf(" full %.0fh", t.FullIn)
+	}
+	return text
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRegression(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var times []time.Time
+	var values []float64
+	// 2 per hour with noise that cancels out
+	for i, noise := range []float64{1, -1, 1, -1} {
+		times = append(times, start.Add(time.Duration(i)*30*time.Minute))
+		values = append(values, 10+float64(i)+noise)
+	}
+	if got := regression(times, values); math.Abs(got-1.2) > 1e-9 {
+		t.Errorf("got slope %v, want 1.2", got)
+	}
+	if got := regression(times[:1], values[:1]); got != 0 {
+		t.Errorf("got slope %v for a single sample, want 0", got)
+	}
+}
+
+func TestRateStatus(t *testing.T) {
+	rising := &limits{Warning: 5, Error: 10}
+	falling := &limits{Warning: -5, Error: -10}
+	for _, tc := range []struct {
+		metric string
+		slope  float64
+		l      *limits
+		want   int
+	}{
+		{"cpu", 4, rising, statusOK},
+		{"cpu", 5, rising, statusWarning},
+		{"cpu", 12, rising, statusError},
+		{"cpu", -12, rising, statusOK},
+		{"conns", -4, falling, statusOK},
+		{"conns", -5, falling, statusWarning},
+		{"conns", -12, falling, statusError},
+		{"conns", 12, falling, statusOK},
+		{"cpu", -12, &limits{Warning: 5, Error: -10}, statusError},
+		{"cpu", 6, &limits{Warning: 5, Error: -10}, statusWarning},
+		// free is checked against the absolute slope with positive limits
+		{"free", -6, rising, statusWarning},
+		{"free", -12, rising, statusError},
+		{"free", 12, rising, statusError},
+		{"free", 6, falling, statusOK},
+		{"free", -6, falling, statusWarning},
+		{"cpu", 100, nil, statusOK},
+	} {
+		if got := worstStatus(rateStatus(tc.metric, tc.slope, tc.l)); got != tc.want {
+			t.Errorf("rateStatus(%s, %v, %v) = %d, want %d", tc.metric, tc.slope, tc.l, got, tc.want)
+		}
+	}
+}
+
+func TestFullIn(t *testing.T) {
+	now := time.Now()
+	m := &machine{Name: "test"}
+	// the first mount grows 10% per hour, the second one is steady
+	for i, used := range []int32{40, 50, 60} {
+		m.History = append(m.History, sample{Time: now.Add(time.Duration(i-3) * time.Hour), Storage: []int32{used, 10}})
+	}
+	m.Storage = measurement{Value: []int32{70, 10}, FullIn: &limits{Warning: 24, Error: 6}}
+	updateTrends(m)
+	tr, ok := getTrend(m, "storage")
+	if !ok {
+		t.Fatal("no storage trend")
+	}
+	if math.Abs(tr.Slope-10) > 0.01 || math.Abs(tr.FullIn-3) > 0.01 {
+		t.Errorf("got slope %.2f, full in %.2fh, want 10 and 3h", tr.Slope, tr.FullIn)
+	}
+	if tr.Status != statusError {
+		t.Errorf("got status %d, want error", tr.Status)
+	}
+
+	m.Storage.FullIn = &limits{Warning: 2, Error: 1}
+	updateTrends(m)
+	if tr, _ := getTrend(m, "storage"); tr.Status != statusOK {
+		t.Errorf("got status %d for full in 3h, want OK", tr.Status)
+	}
+
+	m.Storage.Value = []int32{40, 10}
+	m.History = m.History[:1]
+	updateTrends(m)
+	if _, ok := getTrend(m, "storage"); ok {
+		t.Errorf("got a trend from too few samples")
+	}
+}